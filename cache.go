@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// 客户端缓存默认最多保存的响应数量
+const _defaultCacheSize = 1000
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// flightGroup 合并并发的相同请求,相同key的请求只执行一次并共享结果
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do 在新的goroutine中执行fn并等待结果,相同key的并发调用只执行一次fn,shared表示是否加入了其他请求发起的执行.
+// 每个调用方只等待到自己的ctx结束,此时返回ctx的错误;fn不能使用调用方的ctx,
+// 否则发起执行的请求被取消时所有等待的请求都会失败,参见 detach.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		c = &flightCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(key, c, fn)
+	}
+	g.mutex.Unlock()
+	select {
+	case <-c.done:
+		return c.val, c.err, shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), shared
+	}
+}
+
+func (g *flightGroup) run(key string, c *flightCall, fn func() (interface{}, error)) {
+	defer func() {
+		// 合并执行的请求不在recovery拦截器中运行,与recovery一样记录堆栈并返回metacode.ServerErr
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			rs := runtime.Stack(buf, false)
+			if rs > size {
+				rs = size
+			}
+			buf = buf[:rs]
+			fmt.Fprintf(os.Stderr, "grpc coalesced call panic: %s\n%v\n%s\n", key, r, buf)
+			c.val, c.err = nil, metacode.ServerErr
+		}
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+}
+
+// detachedContext 保留父ctx中的值(元数据、链路追踪等),但不继承父ctx的取消以及截止时间
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// detach 返回不会随ctx取消的上下文,用于合并执行的请求
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+// requestKey 使用方法名和确定性序列化的请求作为缓存或者合并请求的key
+func requestKey(method string, req interface{}) (string, bool) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(msg); err != nil {
+		return "", false
+	}
+	return method + "|" + string(buf.Bytes()), true
+}
+
+// responseCache 客户端响应缓存
+type responseCache struct {
+	mutex sync.Mutex
+	size  int
+	store IdempotencyStore
+	group flightGroup
+}
+
+// get 返回容量为size的存储,容量发生变化时重新创建
+func (rc *responseCache) get(size int) IdempotencyStore {
+	if size <= 0 {
+		size = _defaultCacheSize
+	}
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.store == nil || rc.size != size {
+		rc.store, rc.size = NewMemoryStore(size), size
+	}
+	return rc.store
+}
+
+// cache 返回缓存只读方法响应的客户端拦截器,只对Method中配置了cacheTTL的方法生效,
+// 并发的相同请求只会发起一次调用,失败的响应不会被缓存.
+func (c *Client) cache() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ttl := time.Duration(c.methodConfig(method).CacheTTL)
+		msg, ok := reply.(proto.Message)
+		if ttl <= 0 || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		key, ok := requestKey(method, req)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		c.mutex.RLock()
+		size := c.conf.CacheSize
+		c.mutex.RUnlock()
+		store := c.responses.get(size)
+		if b, ok, _ := store.Get(ctx, key); ok && proto.Unmarshal(b, msg) == nil {
+			metricClientCacheTotal.Inc(method, "hit")
+			return nil
+		}
+		// 合并执行的请求使用独立的响应以及不会随发起请求取消的上下文,超时时间由方法配置决定
+		val, err, shared := c.responses.group.Do(ctx, key, func() (interface{}, error) {
+			r := proto.Clone(msg)
+			r.Reset()
+			if err := invoker(detach(ctx), method, req, r, cc, opts...); err != nil {
+				return nil, err
+			}
+			b, err := proto.Marshal(r)
+			if err == nil {
+				_ = store.Set(context.Background(), key, b, ttl)
+			}
+			return b, err
+		})
+		if shared {
+			metricClientCacheTotal.Inc(method, "coalesced")
+		} else {
+			metricClientCacheTotal.Inc(method, "miss")
+		}
+		if err != nil {
+			if err == ctx.Err() {
+				return ToMetaCode(FromError(err))
+			}
+			return err
+		}
+		return proto.Unmarshal(val.([]byte), msg)
+	}
+}
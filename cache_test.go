@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCache(t *testing.T) {
+	var count int32
+	_, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&count, 1)
+		if req.Name == "error" {
+			return nil, metacode.NothingFound
+		}
+		time.Sleep(time.Millisecond * 50)
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second), Method: map[string]*ClientConfig{
+			"/testproto.Greeter/SayHello": {Timeout: utils.Duration(time.Second), CacheTTL: utils.Duration(time.Minute)},
+		}})
+	defer cancel()
+
+	t.Run("hit", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		for i := 0; i < 3; i++ {
+			reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "a"})
+			assert.Nil(t, err)
+			assert.Equal(t, "a", reply.Message)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+		_, _ = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "b"})
+		assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	})
+	t.Run("coalesce", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "c"})
+				assert.Nil(t, err)
+				assert.Equal(t, "c", reply.Message)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+	t.Run("cancel", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		leader := make(chan error, 1)
+		go func() {
+			_, err := cli.SayHello(ctx, &pb.HelloRequest{Name: "d"})
+			leader <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		follower := make(chan error, 1)
+		go func() {
+			reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "d"})
+			if err == nil {
+				assert.Equal(t, "d", reply.Message)
+			}
+			follower <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		// 发起请求的调用方取消后,等待的请求仍然得到结果
+		cancel()
+		assert.NotNil(t, <-leader)
+		assert.Nil(t, <-follower)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+		// 等待的请求在自己的ctx结束时提前返回
+		short, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		go func() { _, _ = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "e"}) }()
+		time.Sleep(time.Millisecond * 5)
+		start := time.Now()
+		_, err := cli.SayHello(short, &pb.HelloRequest{Name: "e"})
+		assert.NotNil(t, err)
+		assert.True(t, time.Since(start) < time.Millisecond*40)
+	})
+	t.Run("error", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		for i := 0; i < 2; i++ {
+			_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "error"})
+			assert.True(t, metacode.EqualError(metacode.NothingFound, err))
+		}
+		// 失败的响应不会被缓存
+		assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	})
+}
@@ -0,0 +1,138 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aluka-7/metacode"
+)
+
+// 默认的错误码分类
+const (
+	ClassSuccess     = "success"      // 成功
+	ClassClientError = "client_error" // 调用方错误,例如参数错误、未认证、超出限制等
+	ClassServerError = "server_error" // 服务端错误
+	ClassBusiness    = "business"     // 业务错误码
+)
+
+// code标签默认最多保留的不同错误码数量
+const _defaultMaxCodes = 100
+
+// CodeMetricConfig 错误码监控配置
+type CodeMetricConfig struct {
+	Classes  []CodeClass `json:"classes"`  // 自定义错误码分类,优先于默认分类
+	MaxCodes int         `json:"maxCodes"` // code标签最多保留的不同错误码数量,超出后使用分类名称代替,默认值为 100
+}
+
+// CodeClass 将[Min,Max]范围内的错误码归为名称为Name的一类
+type CodeClass struct {
+	Name string `json:"name"`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
+}
+
+// classify 返回错误码所属的分类
+func classify(code int, conf *CodeMetricConfig) string {
+	if conf != nil {
+		for _, c := range conf.Classes {
+			if code >= c.Min && code <= c.Max {
+				return c.Name
+			}
+		}
+	}
+	switch {
+	case code == metacode.OK.Code() || code == metacode.Success.Code():
+		return ClassSuccess
+	case code > 0:
+		return ClassBusiness
+	case code <= -400 && code > -500, code == metacode.LimitExceed.Code(), code == metacode.ValidateErr.Code():
+		return ClassClientError
+	}
+	return ClassServerError
+}
+
+// codeLabeler 限制code标签的基数,超出数量限制的新错误码使用分类名称作为标签值
+type codeLabeler struct {
+	mutex sync.RWMutex
+	seen  map[int]struct{}
+}
+
+func (l *codeLabeler) label(code int, conf *CodeMetricConfig) string {
+	max := _defaultMaxCodes
+	if conf != nil && conf.MaxCodes > 0 {
+		max = conf.MaxCodes
+	}
+	l.mutex.RLock()
+	_, ok := l.seen[code]
+	l.mutex.RUnlock()
+	if ok {
+		return strconv.Itoa(code)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok = l.seen[code]; !ok {
+		if len(l.seen) >= max {
+			return classify(code, conf)
+		}
+		if l.seen == nil {
+			l.seen = make(map[int]struct{})
+		}
+		l.seen[code] = struct{}{}
+	}
+	return strconv.Itoa(code)
+}
+
+// ErrorHook 错误码告警回调,在请求的goroutine中同步执行,不应执行耗时的操作.
+type ErrorHook func(ctx context.Context, method string, code metacode.Codes)
+
+type errorHook struct {
+	match func(code int, class string) bool
+	hook  ErrorHook
+}
+
+// OnCode 注册在返回指定错误码时触发的回调,未指定错误码时对所有不成功的请求触发.
+func (s *Server) OnCode(hook ErrorHook, codes ...metacode.Codes) *Server {
+	set := make(map[int]struct{}, len(codes))
+	for _, c := range codes {
+		set[c.Code()] = struct{}{}
+	}
+	return s.addErrorHook(hook, func(code int, class string) bool {
+		if len(set) == 0 {
+			return class != ClassSuccess
+		}
+		_, ok := set[code]
+		return ok
+	})
+}
+
+// OnClass 注册在返回指定分类(包括CodeMetricConfig中的自定义分类)的错误码时触发的回调.
+func (s *Server) OnClass(hook ErrorHook, classes ...string) *Server {
+	set := make(map[string]struct{}, len(classes))
+	for _, c := range classes {
+		set[c] = struct{}{}
+	}
+	return s.addErrorHook(hook, func(code int, class string) bool {
+		_, ok := set[class]
+		return ok
+	})
+}
+
+func (s *Server) addErrorHook(hook ErrorHook, match func(code int, class string) bool) *Server {
+	s.mutex.Lock()
+	s.hooks = append(s.hooks, errorHook{match: match, hook: hook})
+	s.mutex.Unlock()
+	return s
+}
+
+// fireErrorHooks 触发匹配错误码的回调
+func (s *Server) fireErrorHooks(ctx context.Context, method string, cause metacode.Codes, class string) {
+	s.mutex.RLock()
+	hooks := s.hooks
+	s.mutex.RUnlock()
+	for _, h := range hooks {
+		if h.match(cause.Code(), class) {
+			h.hook(ctx, method, cause)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, ClassSuccess, classify(metacode.OK.Code(), nil))
+	assert.Equal(t, ClassClientError, classify(metacode.RequestErr.Code(), nil))
+	assert.Equal(t, ClassClientError, classify(metacode.ValidateErr.Code(), nil))
+	assert.Equal(t, ClassServerError, classify(metacode.ServerErr.Code(), nil))
+	assert.Equal(t, ClassServerError, classify(metacode.Deadline.Code(), nil))
+	assert.Equal(t, ClassBusiness, classify(20001, nil))
+
+	conf := &CodeMetricConfig{Classes: []CodeClass{{Name: "order", Min: 20000, Max: 29999}}}
+	assert.Equal(t, "order", classify(20001, conf))
+	assert.Equal(t, ClassBusiness, classify(30001, conf))
+}
+
+func TestCodeLabeler(t *testing.T) {
+	var l codeLabeler
+	conf := &CodeMetricConfig{MaxCodes: 2, Classes: []CodeClass{{Name: "order", Min: 20000, Max: 29999}}}
+	assert.Equal(t, "0", l.label(0, conf))
+	assert.Equal(t, "20001", l.label(20001, conf))
+	assert.Equal(t, "order", l.label(20002, conf))
+	assert.Equal(t, ClassServerError, l.label(-500, conf))
+	// 已经出现过的错误码保留原始值
+	assert.Equal(t, "20001", l.label(20001, conf))
+}
+
+func TestErrorHook(t *testing.T) {
+	var codes, classes []int
+	srv, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		switch req.Name {
+		case "conflict":
+			return nil, metacode.Conflict
+		case "order":
+			return nil, metacode.Code(20001)
+		}
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second), CodeMetric: &CodeMetricConfig{
+		Classes: []CodeClass{{Name: "order", Min: 20000, Max: 29999}},
+	}}, &ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	defer cancel()
+	srv.OnCode(func(ctx context.Context, method string, code metacode.Codes) {
+		codes = append(codes, code.Code())
+	}, metacode.Conflict)
+	srv.OnClass(func(ctx context.Context, method string, code metacode.Codes) {
+		classes = append(classes, code.Code())
+	}, "order")
+
+	for _, name := range []string{"conflict", "order", "ok"} {
+		_, _ = cli.SayHello(context.Background(), &pb.HelloRequest{Name: name})
+	}
+	assert.Equal(t, []int{metacode.Conflict.Code()}, codes)
+	assert.Equal(t, []int{20001}, classes)
+}
@@ -25,12 +25,19 @@ import (
 type ClientConfig struct {
 	Dial                utils.Duration           `json:"dial"`
 	Timeout             utils.Duration           `json:"timeout"`
-	Method              map[string]*ClientConfig `json:"method"`
+	Method              map[string]*ClientConfig `json:"method"` // 方法级配置,只有timeout、retry、hedge、cacheTTL生效,未设置时继承全局配置
 	NonBlock            bool                     `json:"nonBlock"`
 	KeepAliveInterval   utils.Duration           `json:"keepAliveInterval"`
 	KeepAliveTimeout    utils.Duration           `json:"keepAliveTimeout"`
 	PermitWithoutStream bool                     `json:"permitWithoutStream"`
 	EnableLog           bool                     `json:"enableLog"`
+	Retry               int                      `json:"retry"`     // 最大重试次数,仅当服务端返回的错误携带RetryInfo时按照建议的间隔重试
+	Hedge               *HedgePolicy             `json:"hedge"`     // 对冲请求配置,一般在Method中为延迟敏感的幂等方法单独配置
+	Throttle            *ThrottleConfig          `json:"throttle"`  // 客户端自适应限流配置,只在全局配置中生效
+	CacheTTL            utils.Duration           `json:"cacheTTL"`  // 响应缓存时间,一般在Method中为只读方法单独配置,为零时不缓存
+	CacheSize           int                      `json:"cacheSize"` // 响应缓存最多保存的数量,只在全局配置中生效,默认值为 1000
+	Fault               *FaultConfig             `json:"fault"`     // 故障注入配置,只在全局配置中生效,默认关闭
+	Balancer            string                   `json:"balancer"`  // 负载均衡策略名称,例如按照染色标记路由的 color,为空时使用gRPC默认策略
 }
 
 // Client 客户端是框架的客户端实例,它包含ctx,opt和拦截器。
@@ -41,6 +48,13 @@ type Client struct {
 
 	opts     []grpc.DialOption
 	handlers []grpc.UnaryClientInterceptor
+
+	responses responseCache
+	shadow    shadow
+	router    router
+	inflight  int64
+	transport []grpc.DialOption // 主连接的传输选项,流量拆分以及流量镜像的连接使用相同的选项
+	dialed    bool
 }
 
 // TimeoutCallOption 超时选项.
@@ -74,11 +88,7 @@ func (c *Client) handle(caller []string) grpc.UnaryClientInterceptor {
 		// 设置元数据
 		gmd = metadata.MD{metacode.Caller: caller}
 		_ = trace.Inject(t, trace.GRPCFormat, gmd)
-		c.mutex.RLock()
-		if conf, ok = c.conf.Method[method]; !ok {
-			conf = c.conf
-		}
-		c.mutex.RUnlock()
+		conf = c.methodConfig(method)
 
 		var timeOpt *TimeoutCallOption
 		for _, opt := range opts {
@@ -99,7 +109,9 @@ func (c *Client) handle(caller []string) grpc.UnaryClientInterceptor {
 			if v, ok := value.(string); ok {
 				gmd[key] = []string{v}
 			}
-		}, metacode.IsOutgoingKey)
+		}, func(key string) bool {
+			return metacode.IsOutgoingKey(key) || key == Color
+		})
 		// merge with old metadata if exists
 		if old, ok := metadata.FromOutgoingContext(ctx); ok {
 			gmd = metadata.Join(gmd, old)
@@ -122,6 +134,43 @@ func (c *Client) handle(caller []string) grpc.UnaryClientInterceptor {
 	}
 }
 
+// retry 返回按照服务端RetryInfo建议的间隔进行重试的客户端拦截器.
+func (c *Client) retry() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		conf := c.methodConfig(method)
+		for i := 0; ; i++ {
+			if err = invoker(ctx, method, req, reply, cc, opts...); err == nil || i >= conf.Retry {
+				return
+			}
+			delay, ok := RetryDelay(err)
+			if !ok {
+				return
+			}
+			// 剩余时间不足以等待重试时直接返回
+			if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= delay {
+				return
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// methodConfig 返回方法级别的配置(已继承全局配置),不存在时返回全局配置.
+func (c *Client) methodConfig(method string) *ClientConfig {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if conf, ok := c.conf.Method[method]; ok {
+		return conf
+	}
+	return c.conf
+}
+
 // NewConn 创建rpc连接.
 func NewConn(target string, conf *ClientConfig, caller []string, opt ...grpc.DialOption) (*grpc.ClientConn, error) {
 	return NewClient(conf, opt...).Dial(context.Background(), target, caller, opt...)
@@ -138,8 +187,12 @@ func NewClient(conf *ClientConfig, opt ...grpc.DialOption) *Client {
 	return c
 }
 
-// SetConfig 热重载客户端配置
+// SetConfig 热重载客户端配置,配置会先填充默认值并校验,不合法时返回错误并继续使用原配置.
 func (c *Client) SetConfig(conf *ClientConfig) (err error) {
+	conf = conf.Defaults()
+	if err = conf.Validate(); err != nil {
+		return
+	}
 	c.mutex.Lock()
 	c.conf = conf
 	c.mutex.Unlock()
@@ -172,24 +225,57 @@ func (c *Client) cloneOpts() []grpc.DialOption {
 	return dialOptions
 }
 
-func (c *Client) dial(ctx context.Context, target string, caller []string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error) {
+// dialOptions 返回建立连接使用的选项:全局选项、保活以及负载均衡配置,block为true时阻塞到连接建立
+func (c *Client) dialOptions(block bool, opts ...grpc.DialOption) []grpc.DialOption {
+	c.mutex.RLock()
+	conf := c.conf
+	c.mutex.RUnlock()
 	// 克隆连接配置
 	dialOptions := c.cloneOpts()
-	if !c.conf.NonBlock {
+	if block && !conf.NonBlock {
 		dialOptions = append(dialOptions, grpc.WithBlock())
 	}
 	dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
-		Time:                time.Duration(c.conf.KeepAliveInterval),
-		Timeout:             time.Duration(c.conf.KeepAliveTimeout),
-		PermitWithoutStream: !c.conf.PermitWithoutStream,
+		Time:                time.Duration(conf.KeepAliveInterval),
+		Timeout:             time.Duration(conf.KeepAliveTimeout),
+		PermitWithoutStream: !conf.PermitWithoutStream,
 	}))
-	dialOptions = append(dialOptions, opts...)
+	if conf.Balancer != "" {
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingPolicy":%q}`, conf.Balancer)))
+	}
+	return append(dialOptions, opts...)
+}
+
+// dialSide 使用与主连接相同的选项(包括传输安全)建立流量拆分或者流量镜像的连接,不会阻塞.
+// 请求经过主连接的拦截器后再交给该连接,因此不再添加拦截器;主连接尚未建立时返回nil,建立后再连接.
+func (c *Client) dialSide(target string) (*grpc.ClientConn, error) {
+	c.mutex.RLock()
+	transport, dialed := c.transport, c.dialed
+	c.mutex.RUnlock()
+	if !dialed {
+		return nil, nil
+	}
+	conn, err := grpc.Dial(target, c.dialOptions(false, transport...)...)
+	return conn, errors.WithStack(err)
+}
+
+func (c *Client) dial(ctx context.Context, target string, caller []string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error) {
+	dialOptions := c.dialOptions(true, opts...)
 
 	// 初始化默认处理程序
 	var handlers []grpc.UnaryClientInterceptor
+	handlers = append(handlers, c.track())
 	handlers = append(handlers, c.recovery())
 	handlers = append(handlers, c.clientLogging())
 	handlers = append(handlers, c.handlers...)
+	handlers = append(handlers, c.split(caller))
+	handlers = append(handlers, c.mirror(caller))
+	handlers = append(handlers, c.cache())
+	handlers = append(handlers, c.idempotency())
+	handlers = append(handlers, c.retry())
+	handlers = append(handlers, c.hedge())
+	handlers = append(handlers, c.throttle(target))
+	handlers = append(handlers, c.fault())
 	// 注意:c.handle必须是最后一个拦截器.
 	handlers = append(handlers, c.handle(caller))
 
@@ -204,8 +290,14 @@ func (c *Client) dial(ctx context.Context, target string, caller []string, opts
 	}
 	if conn, err = grpc.DialContext(ctx, target, dialOptions...); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "rpc client: dial %s error %v!", target, err)
+		err = errors.WithStack(err)
+		return
 	}
-	err = errors.WithStack(err)
+	// 记录传输相关的选项,并为主连接建立前配置的流量拆分以及流量镜像建立连接
+	c.mutex.Lock()
+	c.transport, c.dialed = append([]grpc.DialOption(nil), opts...), true
+	c.mutex.Unlock()
+	c.connectSides()
 	return
 }
 
@@ -262,18 +354,16 @@ func chainUnaryClient(handlers []grpc.UnaryClientInterceptor) grpc.UnaryClientIn
 
 	return func(ctx context.Context, method string, req, reply interface{},
 		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		var (
-			i            int
-			chainHandler grpc.UnaryInvoker
-		)
-		chainHandler = func(ictx context.Context, imethod string, ireq, ireply interface{}, ic *grpc.ClientConn, iopts ...grpc.CallOption) error {
-			if i == n-1 {
-				return invoker(ictx, imethod, ireq, ireply, ic, iopts...)
-			}
-			i++
-			return handlers[i](ictx, imethod, ireq, ireply, ic, chainHandler, iopts...)
-		}
+		return handlers[0](ctx, method, req, reply, cc, chainUnaryInvoker(handlers, 0, invoker), opts...)
+	}
+}
 
-		return handlers[0](ctx, method, req, reply, cc, chainHandler, opts...)
+// 返回调用第curr+1个拦截器的invoker,每一层都持有自己的位置,因此重试等拦截器可以多次调用后续的拦截器链.
+func chainUnaryInvoker(handlers []grpc.UnaryClientInterceptor, curr int, invoker grpc.UnaryInvoker) grpc.UnaryInvoker {
+	if curr == len(handlers)-1 {
+		return invoker
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return handlers[curr+1](ctx, method, req, reply, cc, chainUnaryInvoker(handlers, curr+1, invoker), opts...)
 	}
 }
@@ -32,3 +32,21 @@ func TestChainUnaryClient(t *testing.T) {
 		"h1-out",
 	}, orders)
 }
+
+func TestChainUnaryClientReentrant(t *testing.T) {
+	var orders []string
+	retry := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		_ = invoker(ctx, method, req, reply, cc, opts...)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	inner := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		orders = append(orders, "inner")
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	interceptor := chainUnaryClient([]grpc.UnaryClientInterceptor{retry, inner})
+	interceptor(context.Background(), "test", nil, nil, nil, func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		orders = append(orders, "invoker")
+		return nil
+	})
+	assert.Equal(t, []string{"inner", "invoker", "inner", "invoker"}, orders)
+}
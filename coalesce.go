@@ -0,0 +1,50 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+)
+
+// CoalesceConfig 方法级的请求合并配置
+type CoalesceConfig struct {
+	ByCaller bool           `json:"byCaller"` // 是否只合并同一调用方的请求,响应与调用方相关时需要开启
+	Timeout  utils.Duration `json:"timeout"`  // 合并执行的超时时间,默认使用服务器的timeout
+}
+
+// coalesce 返回合并并发相同请求的服务器拦截器,只对Coalesce中配置的方法生效.
+// 方法、序列化后的请求(以及调用方)相同的并发请求只执行一次处理程序,并共享响应或者错误.
+// 处理程序使用不随发起请求取消的上下文执行,每个请求只等待到自己的截止时间.
+func (s *Server) coalesce() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		s.mutex.RLock()
+		conf, ok := s.conf.Coalesce[args.FullMethod]
+		timeout := time.Duration(s.conf.Timeout)
+		s.mutex.RUnlock()
+		if !ok {
+			return handler(ctx, req)
+		}
+		key, ok := requestKey(args.FullMethod, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if conf != nil && conf.ByCaller {
+			key = metacode.ToString(ctx, metacode.Caller) + "|" + key
+		}
+		if conf != nil && conf.Timeout > 0 {
+			timeout = time.Duration(conf.Timeout)
+		}
+		resp, err, shared := s.flights.Do(ctx, key, func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(detach(ctx), timeout)
+			defer cancel()
+			return handler(ctx, req)
+		})
+		if shared {
+			metricServerCoalescedTotal.Inc(args.FullMethod)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,90 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesce(t *testing.T) {
+	var count int32
+	srv, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(time.Millisecond * 50)
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	defer cancel()
+
+	concurrent := func(names ...string) {
+		var wg sync.WaitGroup
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: name})
+				assert.Nil(t, err)
+				assert.Equal(t, name, reply.Message)
+			}(name)
+		}
+		wg.Wait()
+	}
+	// 未配置的方法不合并请求
+	concurrent("a", "a", "a")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+
+	conf := *srv.conf
+	conf.Coalesce = map[string]*CoalesceConfig{"/testproto.Greeter/SayHello": {}}
+	_ = srv.SetConfig(&conf)
+	atomic.StoreInt32(&count, 0)
+	concurrent("a", "a", "a", "b", "b")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// 发起请求的调用方超时后,合并的请求仍然得到结果
+	atomic.StoreInt32(&count, 0)
+	leader := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+		_, err := cli.SayHello(ctx, &pb.HelloRequest{Name: "c"})
+		leader <- err
+	}()
+	time.Sleep(time.Millisecond * 5)
+	reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, "c", reply.Message)
+	assert.NotNil(t, <-leader)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestCoalescePanic(t *testing.T) {
+	var count int32
+	_, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(time.Millisecond * 50)
+		panic("coalesce panic")
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second),
+		Coalesce: map[string]*CoalesceConfig{"/testproto.Greeter/SayHello": {}}},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	defer cancel()
+
+	// 合并执行的处理程序发生panic时,所有调用方都得到metacode.ServerErr
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "a"})
+			assert.True(t, metacode.EqualError(metacode.ServerErr, err), "%v", err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/aluka-7/metacode"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// Color 染色标记的元数据名称,随调用链路在服务端与客户端之间传递.
+	Color = "color"
+	// ColorBalancer 按照染色标记路由的负载均衡策略名称,通过 ClientConfig.Balancer 启用.
+	ColorBalancer = "color"
+)
+
+// colorKey 实例染色标记在 resolver.Address.Attributes 中的key
+type colorKey struct{}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(ColorBalancer, &colorPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// WithColor 为请求设置染色标记,请求以及其后续链路会优先路由到相同染色的实例.
+func WithColor(ctx context.Context, color string) context.Context {
+	md := metacode.Metadata{}
+	metacode.Range(ctx, func(key string, value interface{}) {
+		md[key] = value
+	})
+	md[Color] = color
+	return metacode.NewContext(ctx, md)
+}
+
+// ColorAddress 为服务发现返回的实例地址设置染色标记,未设置染色标记的实例属于默认泳道.
+func ColorAddress(addr resolver.Address, color string) resolver.Address {
+	if addr.Attributes == nil {
+		addr.Attributes = attributes.New(colorKey{}, color)
+	} else {
+		addr.Attributes = addr.Attributes.WithValues(colorKey{}, color)
+	}
+	return addr
+}
+
+// addressColor 返回实例地址的染色标记
+func addressColor(addr resolver.Address) string {
+	if addr.Attributes == nil {
+		return ""
+	}
+	color, _ := addr.Attributes.Value(colorKey{}).(string)
+	return color
+}
+
+type colorPickerBuilder struct{}
+
+func (*colorPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &colorPicker{lanes: make(map[string]*lane)}
+	for sc, sci := range info.ReadySCs {
+		color := addressColor(sci.Address)
+		l, ok := p.lanes[color]
+		if !ok {
+			l = new(lane)
+			p.lanes[color] = l
+		}
+		l.subConns = append(l.subConns, sc)
+		p.all.subConns = append(p.all.subConns, sc)
+	}
+	return p
+}
+
+// lane 同一染色标记的实例,在实例之间轮询
+type lane struct {
+	next     uint32
+	subConns []balancer.SubConn
+}
+
+func (l *lane) pick() balancer.SubConn {
+	n := atomic.AddUint32(&l.next, 1)
+	return l.subConns[(n-1)%uint32(len(l.subConns))]
+}
+
+// colorPicker 将携带染色标记的请求路由到相同染色的实例,没有相同染色的实例时回退到默认泳道,
+// 默认泳道没有实例时在所有实例之间轮询.
+type colorPicker struct {
+	lanes map[string]*lane
+	all   lane
+}
+
+func (p *colorPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var color string
+	if md, ok := metadata.FromOutgoingContext(info.Ctx); ok {
+		if v := md.Get(Color); len(v) > 0 {
+			color = v[0]
+		}
+	}
+	if l, ok := p.lanes[color]; ok {
+		return balancer.PickResult{SubConn: l.pick()}, nil
+	}
+	if color != "" {
+		metricClientColorFallbackTotal.Inc(color)
+	}
+	if l, ok := p.lanes[""]; ok {
+		return balancer.PickResult{SubConn: l.pick()}, nil
+	}
+	return balancer.PickResult{SubConn: p.all.pick()}, nil
+}
@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+func TestColorRouting(t *testing.T) {
+	newServer := func(lane string) (string, func()) {
+		return startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+			return &pb.HelloReply{Success: true, Message: lane + ":" + metacode.ToString(ctx, Color)}, nil
+		})
+	}
+	stable, stopStable := newServer("stable")
+	defer stopStable()
+	blue, stopBlue := newServer("blue")
+	defer stopBlue()
+
+	r := manual.NewBuilderWithScheme("color")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{
+		{Addr: stable},
+		ColorAddress(resolver.Address{Addr: blue}, "blue"),
+	}})
+	client := NewClient(&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second), Balancer: ColorBalancer})
+	conn, err := client.Dial(context.Background(), r.Scheme()+":///greeter", []string{"10000"}, grpc.WithResolvers(r))
+	assert.Nil(t, err)
+	defer conn.Close()
+	cli := pb.NewGreeterClient(conn)
+	// 等待所有实例就绪
+	assert.Eventually(t, func() bool {
+		reply, err := cli.SayHello(WithColor(context.Background(), "blue"), &pb.HelloRequest{Name: "color"})
+		return err == nil && reply.Message == "blue:blue"
+	}, time.Second*5, time.Millisecond*10)
+
+	for i := 0; i < 4; i++ {
+		reply, err := cli.SayHello(WithColor(context.Background(), "blue"), &pb.HelloRequest{Name: "color"})
+		assert.Nil(t, err)
+		assert.Equal(t, "blue:blue", reply.Message)
+		// 没有对应染色的实例时回退到默认泳道,染色标记继续向下游传递
+		reply, err = cli.SayHello(WithColor(context.Background(), "green"), &pb.HelloRequest{Name: "color"})
+		assert.Nil(t, err)
+		assert.Equal(t, "stable:green", reply.Message)
+		reply, err = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "color"})
+		assert.Nil(t, err)
+		assert.Equal(t, "stable:", reply.Message)
+	}
+}
@@ -0,0 +1,215 @@
+package grpc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aluka-7/utils"
+)
+
+// 服务器配置的默认值
+const (
+	_defaultNetwork = "tcp"
+	_defaultAddr    = "0.0.0.0:9000"
+)
+
+// 客户端配置的默认值
+const (
+	_defaultKeepAliveInterval = utils.Duration(time.Second * 60)
+	_defaultKeepAliveTimeout  = utils.Duration(time.Second * 20)
+)
+
+// ConfigErrors 配置校验的错误集合,包含所有不合法的配置项.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// configChecker 收集配置校验的错误
+type configChecker struct {
+	prefix string
+	errs   ConfigErrors
+}
+
+func (c *configChecker) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		c.errs = append(c.errs, fmt.Errorf(c.prefix+format, args...))
+	}
+}
+
+// duration 检查时间配置不能为负数
+func (c *configChecker) duration(name string, d utils.Duration) {
+	c.check(d >= 0, "%s不能为负数: %s", name, time.Duration(d))
+}
+
+func (c *configChecker) percentage(name string, p float64) {
+	c.check(p >= 0 && p <= 100, "%s取值范围为[0,100]: %v", name, p)
+}
+
+func (c *configChecker) fault(conf *FaultConfig) {
+	if conf == nil {
+		return
+	}
+	for i, r := range conf.Rules {
+		c.percentage(fmt.Sprintf("fault.rules[%d].percentage", i), r.Percentage)
+		c.check(r.Delay >= 0, "fault.rules[%d].delay不能为负数", i)
+	}
+}
+
+func (c *configChecker) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+// Defaults 返回填充了默认值的配置副本,不会修改原配置.
+func (c *ServerConfig) Defaults() *ServerConfig {
+	conf := *c
+	if conf.Network == "" {
+		conf.Network = _defaultNetwork
+	}
+	if conf.Addr == "" {
+		conf.Addr = _defaultAddr
+	}
+	if conf.Locale == "" {
+		conf.Locale = defaultLocale
+	}
+	return &conf
+}
+
+// Validate 校验服务器配置,返回所有不合法配置项组成的 ConfigErrors.
+func (c *ServerConfig) Validate() error {
+	var ck configChecker
+	switch c.Network {
+	case "", "tcp", "tcp4", "tcp6", "unix":
+	default:
+		ck.check(false, "network不支持: %s", c.Network)
+	}
+	ck.check(c.Timeout > 0, "timeout必须大于零")
+	ck.duration("idleTimeout", c.IdleTimeout)
+	ck.duration("maxLife", c.MaxLifeTime)
+	ck.duration("closeWait", c.ForceCloseWait)
+	ck.duration("keepaliveInterval", c.KeepAliveInterval)
+	ck.duration("keepaliveTimeout", c.KeepAliveTimeout)
+	if d := c.Deadline; d != nil {
+		ck.check(d.ReserveRatio >= 0 && d.ReserveRatio < 1, "deadline.reserveRatio取值范围为[0,1): %v", d.ReserveRatio)
+		ck.duration("deadline.minReserve", d.MinReserve)
+		ck.duration("deadline.floor", d.Floor)
+		for method, timeout := range d.Method {
+			ck.check(timeout > 0, "deadline.method[%s]必须大于零", method)
+		}
+	}
+	if l := c.Limiter; l != nil {
+		ck.check(l.Type == "" || l.Type == LimiterStatic || l.Type == LimiterGradient, "limiter.type不支持: %s", l.Type)
+		ck.check(l.Limit >= 0 && l.MinLimit >= 0 && l.MaxLimit >= 0 && l.QueueSize >= 0, "limiter的数量配置不能为负数")
+		ck.check(l.MaxLimit == 0 || l.MinLimit <= l.MaxLimit, "limiter.minLimit不能大于maxLimit")
+		ck.check(l.QueueTimeout >= 0, "limiter.queueTimeout不能为负数")
+	}
+	if m := c.CodeMetric; m != nil {
+		ck.check(m.MaxCodes >= 0, "codeMetric.maxCodes不能为负数")
+		for _, class := range m.Classes {
+			ck.check(class.Name != "" && class.Min <= class.Max, "codeMetric.classes[%s]的名称不能为空且min不能大于max", class.Name)
+		}
+	}
+	if i := c.Idempotency; i != nil {
+		ck.check(i.TTL >= 0 && i.Size >= 0, "idempotency.ttl以及size不能为负数")
+	}
+	for method, cc := range c.Coalesce {
+		if cc != nil {
+			ck.duration(fmt.Sprintf("coalesce[%s].timeout", method), cc.Timeout)
+		}
+	}
+	ck.fault(c.Fault)
+	return ck.err()
+}
+
+// Defaults 返回填充了默认值的配置副本,不会修改原配置.
+// Method中只有timeout、retry、hedge、cacheTTL是方法级的配置项,未设置时继承全局配置,其余配置项始终使用全局配置.
+func (c *ClientConfig) Defaults() *ClientConfig {
+	conf := *c
+	if conf.KeepAliveInterval == 0 {
+		conf.KeepAliveInterval = _defaultKeepAliveInterval
+	}
+	if conf.KeepAliveTimeout == 0 {
+		conf.KeepAliveTimeout = _defaultKeepAliveTimeout
+	}
+	if len(c.Method) > 0 {
+		conf.Method = make(map[string]*ClientConfig, len(c.Method))
+		for name, m := range c.Method {
+			if m == nil {
+				m = new(ClientConfig)
+			}
+			conf.Method[name] = m.inherit(&conf)
+		}
+	}
+	return &conf
+}
+
+// inherit 返回方法级配置副本,只有Timeout、Retry、Hedge、CacheTTL是方法级的配置项,未设置时继承parent;
+// 其余配置项作用于连接或者整个客户端,始终使用parent的配置,方法中设置的值不会生效.
+func (c *ClientConfig) inherit(parent *ClientConfig) *ClientConfig {
+	conf := *parent
+	conf.Method = nil
+	if c.Timeout != 0 {
+		conf.Timeout = c.Timeout
+	}
+	if c.Retry != 0 {
+		conf.Retry = c.Retry
+	}
+	if c.Hedge != nil {
+		conf.Hedge = c.Hedge
+	}
+	if c.CacheTTL != 0 {
+		conf.CacheTTL = c.CacheTTL
+	}
+	return &conf
+}
+
+// Validate 校验客户端配置以及继承全局配置后的方法级配置,返回所有不合法配置项组成的 ConfigErrors.
+func (c *ClientConfig) Validate() error {
+	var ck configChecker
+	c.validate(&ck)
+	names := make([]string, 0, len(c.Method))
+	for name := range c.Method {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := c.Method[name]
+		if m == nil {
+			m = new(ClientConfig)
+		}
+		mck := configChecker{prefix: fmt.Sprintf("method[%s].", name)}
+		m.inherit(c).validate(&mck)
+		ck.errs = append(ck.errs, mck.errs...)
+	}
+	return ck.err()
+}
+
+func (c *ClientConfig) validate(ck *configChecker) {
+	ck.check(c.Timeout > 0, "timeout必须大于零")
+	ck.check(c.KeepAliveInterval > 0, "keepAliveInterval必须大于零")
+	ck.check(c.KeepAliveTimeout > 0, "keepAliveTimeout必须大于零")
+	ck.duration("dial", c.Dial)
+	ck.duration("cacheTTL", c.CacheTTL)
+	ck.check(c.Retry >= 0, "retry不能为负数")
+	ck.check(c.CacheSize >= 0, "cacheSize不能为负数")
+	if h := c.Hedge; h != nil {
+		ck.check(h.Delay >= 0 && h.MaxHedges >= 0, "hedge.delay以及maxHedges不能为负数")
+	}
+	if t := c.Throttle; t != nil {
+		ck.check(t.K >= 0 && t.Window >= 0 && t.Bucket >= 0, "throttle的配置不能为负数")
+		// 每个桶的时长向下取整后不能为零
+		window, bucket := t.window()
+		ck.check(window/time.Duration(bucket) >= time.Millisecond, "throttle.window/bucket不能小于1ms")
+	}
+	ck.fault(c.Fault)
+}
@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConfigDefaults(t *testing.T) {
+	conf := &ServerConfig{Timeout: utils.Duration(time.Second)}
+	d := conf.Defaults()
+	assert.Equal(t, "tcp", d.Network)
+	assert.Equal(t, "0.0.0.0:9000", d.Addr)
+	// 不修改原配置
+	assert.Empty(t, conf.Network)
+	assert.Nil(t, d.Validate())
+
+	err := (&ServerConfig{
+		Network:     "udp",
+		IdleTimeout: utils.Duration(-time.Second),
+		Limiter:     &LimiterConfig{Type: "vegas", MinLimit: 10, MaxLimit: 5},
+		Fault:       &FaultConfig{Rules: []*FaultRule{{Percentage: 120}}},
+	}).Validate()
+	errs, ok := err.(ConfigErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 6)
+}
+
+func TestClientConfigInherit(t *testing.T) {
+	hedge := &HedgePolicy{Delay: utils.Duration(time.Millisecond * 10), MaxHedges: 1, Idempotent: true}
+	conf := (&ClientConfig{
+		Timeout:   utils.Duration(time.Second),
+		Retry:     2,
+		EnableLog: true,
+		Hedge:     hedge,
+		Method: map[string]*ClientConfig{
+			"/testproto.Greeter/SayHello": {Timeout: utils.Duration(time.Millisecond * 100)},
+			"/testproto.Greeter/Other":    {Retry: 1, NonBlock: true, Dial: utils.Duration(time.Second)},
+		},
+	}).Defaults()
+	assert.Nil(t, conf.Validate())
+	assert.Equal(t, _defaultKeepAliveInterval, conf.KeepAliveInterval)
+
+	m := conf.Method["/testproto.Greeter/SayHello"]
+	assert.Equal(t, utils.Duration(time.Millisecond*100), m.Timeout)
+	assert.Equal(t, 2, m.Retry)
+	assert.True(t, m.EnableLog)
+	assert.Same(t, hedge, m.Hedge)
+	assert.Equal(t, _defaultKeepAliveTimeout, m.KeepAliveTimeout)
+
+	m = conf.Method["/testproto.Greeter/Other"]
+	assert.Equal(t, utils.Duration(time.Second), m.Timeout)
+	assert.Equal(t, 1, m.Retry)
+	// 作用于连接或者整个客户端的配置项始终使用全局配置
+	assert.False(t, m.NonBlock)
+	assert.Equal(t, conf.Dial, m.Dial)
+	assert.True(t, m.EnableLog)
+}
+
+func TestClientSetConfigValidate(t *testing.T) {
+	c := NewClient(&ClientConfig{Timeout: utils.Duration(time.Second)})
+	err := c.SetConfig(&ClientConfig{Retry: -1, Method: map[string]*ClientConfig{
+		"/testproto.Greeter/SayHello": {Timeout: utils.Duration(-time.Second)},
+	}})
+	assert.EqualError(t, err, "timeout必须大于零; retry不能为负数; method[/testproto.Greeter/SayHello].timeout必须大于零; method[/testproto.Greeter/SayHello].retry不能为负数")
+	// 不合法的配置不会生效
+	assert.Equal(t, utils.Duration(time.Second), c.conf.Timeout)
+
+	err = c.SetConfig(&ClientConfig{Timeout: utils.Duration(time.Second), KeepAliveInterval: utils.Duration(time.Second),
+		KeepAliveTimeout: utils.Duration(time.Second), Throttle: &ThrottleConfig{Window: utils.Duration(time.Millisecond * 10), Bucket: 40}})
+	assert.EqualError(t, err, "throttle.window/bucket不能小于1ms")
+}
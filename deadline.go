@@ -0,0 +1,72 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+)
+
+// 默认为网络传输以及响应预留的时间
+const _defaultMinReserve = time.Millisecond * 20
+
+// DeadlinePolicy 服务端截止时间传递策略,用于从调用方传递过来的剩余时间中计算本次调用可用的时间.
+type DeadlinePolicy struct {
+	ReserveRatio float64                   `json:"reserveRatio"` // 从调用方剩余时间中为网络传输以及响应预留的比例,取值范围为[0,1)
+	MinReserve   utils.Duration            `json:"minReserve"`   // 最少预留的时间,默认值为 20ms
+	Floor        utils.Duration            `json:"floor"`        // 预留后剩余时间低于该值时直接返回 metacode.Deadline,为零时不拒绝
+	Method       map[string]utils.Duration `json:"method"`       // 方法级别的超时上限,优先于 ServerConfig.Timeout
+}
+
+// deadlineRejected 标记剩余时间低于下限的调用
+type deadlineRejected struct{}
+
+// deadline 拒绝剩余时间低于下限的调用,位于日志拦截器之后,使拒绝同样计入错误码指标以及错误钩子.
+func (s *Server) deadline() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if rejected, _ := ctx.Value(deadlineRejected{}).(bool); rejected {
+			return nil, metacode.Deadline
+		}
+		return handler(ctx, req)
+	}
+}
+
+// timeout 根据调用方的剩余时间计算本次调用的超时时间,ok为false表示剩余时间不足应直接拒绝.
+// 未设置策略时预留20ms,与之前的行为一致.
+func (p *DeadlinePolicy) timeout(ctx context.Context, method string, max time.Duration) (timeout time.Duration, ok bool) {
+	reserve := _defaultMinReserve
+	var ratio float64
+	var floor time.Duration
+	if p != nil {
+		if v, ok := p.Method[method]; ok && v > 0 {
+			max = time.Duration(v)
+		}
+		if p.MinReserve > 0 {
+			reserve = time.Duration(p.MinReserve)
+		}
+		ratio = p.ReserveRatio
+		floor = time.Duration(p.Floor)
+	}
+	timeout = max
+	dl, has := ctx.Deadline()
+	if !has {
+		return timeout, true
+	}
+	budget := time.Until(dl)
+	metricServerReqBudget.Observe(int64(budget/time.Millisecond), method)
+	if r := time.Duration(float64(budget) * ratio); r > reserve {
+		reserve = r
+	}
+	if budget-reserve > 0 {
+		budget = budget - reserve
+	}
+	if floor > 0 && budget < floor {
+		return 0, false
+	}
+	if timeout > budget {
+		timeout = budget
+	}
+	return timeout, true
+}
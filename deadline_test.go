@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlinePolicy(t *testing.T) {
+	method := "/testproto.Greeter/SayHello"
+	t.Run("no deadline", func(t *testing.T) {
+		var p *DeadlinePolicy
+		timeout, ok := p.timeout(context.Background(), method, time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, timeout)
+	})
+	t.Run("default reserve", func(t *testing.T) {
+		var p *DeadlinePolicy
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		timeout, ok := p.timeout(ctx, method, time.Second)
+		assert.True(t, ok)
+		assert.True(t, timeout <= time.Millisecond*180 && timeout > time.Millisecond*170, "timeout: %v", timeout)
+	})
+	t.Run("reserve ratio", func(t *testing.T) {
+		p := &DeadlinePolicy{ReserveRatio: 0.5, MinReserve: utils.Duration(time.Millisecond * 10)}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		timeout, ok := p.timeout(ctx, method, time.Second)
+		assert.True(t, ok)
+		assert.True(t, timeout <= time.Millisecond*100 && timeout > time.Millisecond*90, "timeout: %v", timeout)
+	})
+	t.Run("method cap", func(t *testing.T) {
+		p := &DeadlinePolicy{Method: map[string]utils.Duration{method: utils.Duration(time.Millisecond * 50)}}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		timeout, ok := p.timeout(ctx, method, time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, time.Millisecond*50, timeout)
+	})
+	t.Run("floor", func(t *testing.T) {
+		p := &DeadlinePolicy{Floor: utils.Duration(time.Millisecond * 100)}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		_, ok := p.timeout(ctx, method, time.Second)
+		assert.False(t, ok)
+	})
+}
+
+func TestDeadlineFloor(t *testing.T) {
+	var called bool
+	srv, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		called = true
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second), Deadline: &DeadlinePolicy{Floor: utils.Duration(time.Millisecond * 100)}},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Millisecond * 50)})
+	defer cancel()
+	var codes []int
+	srv.OnCode(func(ctx context.Context, method string, code metacode.Codes) {
+		codes = append(codes, code.Code())
+	}, metacode.Deadline)
+
+	_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "floor"})
+	assert.True(t, metacode.EqualError(metacode.Deadline, err), "%v", err)
+	assert.False(t, called)
+	// 拒绝同样经过日志拦截器,触发错误钩子
+	assert.Equal(t, []int{metacode.Deadline.Code()}, codes)
+}
@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorBuilder 构建携带google.rpc标准错误详情(errdetails)的业务错误,
+// 错误详情会同时出现在metacode状态以及gRPC状态中,便于非Go客户端识别.
+type ErrorBuilder struct {
+	st  *metacode.Status
+	err error
+}
+
+// NewErrorBuilder 使用错误码和错误信息创建ErrorBuilder.
+func NewErrorBuilder(code metacode.Code, message string) *ErrorBuilder {
+	return &ErrorBuilder{st: metacode.Error(code, message)}
+}
+
+// WithDetails 附加任意的错误详情.
+func (b *ErrorBuilder) WithDetails(details ...proto.Message) *ErrorBuilder {
+	if _, err := b.st.WithDetails(details...); err != nil && b.err == nil {
+		b.err = errors.WithStack(err)
+	}
+	return b
+}
+
+// WithRetryInfo 附加RetryInfo,告知客户端在delay之后可以重试.
+func (b *ErrorBuilder) WithRetryInfo(delay time.Duration) *ErrorBuilder {
+	return b.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(delay)})
+}
+
+// WithErrorInfo 附加ErrorInfo,用于描述错误的原因、所属领域以及附加信息.
+func (b *ErrorBuilder) WithErrorInfo(reason, domain string, md map[string]string) *ErrorBuilder {
+	return b.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: domain, Metadata: md})
+}
+
+// WithBadRequest 附加BadRequest,用于描述请求中的非法字段.
+func (b *ErrorBuilder) WithBadRequest(violations ...*errdetails.BadRequest_FieldViolation) *ErrorBuilder {
+	return b.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+}
+
+// WithQuotaFailure 附加QuotaFailure,用于描述超出的配额.
+func (b *ErrorBuilder) WithQuotaFailure(violations ...*errdetails.QuotaFailure_Violation) *ErrorBuilder {
+	return b.WithDetails(&errdetails.QuotaFailure{Violations: violations})
+}
+
+// Err 返回构建的错误,如果附加错误详情失败则返回该失败原因.
+func (b *ErrorBuilder) Err() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.st
+}
+
+// isStandardDetail 判断是否为google.rpc标准错误详情
+func isStandardDetail(msg proto.Message) bool {
+	return strings.HasPrefix(proto.MessageName(msg), "google.rpc.")
+}
+
+// errorDetails 获取错误中携带的错误详情,支持metacode.Codes以及gRPC状态错误
+func errorDetails(err error) []interface{} {
+	if err == nil {
+		return nil
+	}
+	cause := errors.Cause(err)
+	if ec, ok := cause.(metacode.Codes); ok {
+		return ec.Details()
+	}
+	if gst, ok := status.FromError(cause); ok {
+		return ToMetaCode(gst).Details()
+	}
+	return nil
+}
+
+// RetryDelay 返回错误详情中RetryInfo建议的重试间隔.
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, detail := range errorDetails(err) {
+		if ri, ok := detail.(*errdetails.RetryInfo); ok {
+			if delay, err := ptypes.Duration(ri.GetRetryDelay()); err == nil {
+				return delay, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// ErrorInfo 返回错误详情中的ErrorInfo.
+func ErrorInfo(err error) (*errdetails.ErrorInfo, bool) {
+	for _, detail := range errorDetails(err) {
+		if ei, ok := detail.(*errdetails.ErrorInfo); ok {
+			return ei, true
+		}
+	}
+	return nil, false
+}
+
+// BadRequest 返回错误详情中的BadRequest.
+func BadRequest(err error) (*errdetails.BadRequest, bool) {
+	for _, detail := range errorDetails(err) {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			return br, true
+		}
+	}
+	return nil, false
+}
+
+// QuotaFailure 返回错误详情中的QuotaFailure.
+func QuotaFailure(err error) (*errdetails.QuotaFailure, bool) {
+	for _, detail := range errorDetails(err) {
+		if qf, ok := detail.(*errdetails.QuotaFailure); ok {
+			return qf, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,80 @@
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorBuilder(t *testing.T) {
+	err := NewErrorBuilder(metacode.LimitExceed, "quota exceeded").
+		WithRetryInfo(time.Second).
+		WithErrorInfo("QUOTA", "aluka", map[string]string{"user": "2233"}).
+		WithQuotaFailure(&errdetails.QuotaFailure_Violation{Subject: "user:2233", Description: "daily limit"}).
+		Err()
+	gst := FromError(err)
+	assert.Equal(t, codes.ResourceExhausted, gst.Code())
+	// metacode状态以及三个标准错误详情
+	assert.Len(t, gst.Details(), 4)
+
+	ec := ToMetaCode(gst)
+	assert.Equal(t, metacode.LimitExceed.Code(), ec.Code())
+	delay, ok := RetryDelay(ec)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+	ei, ok := ErrorInfo(ec)
+	assert.True(t, ok)
+	assert.Equal(t, "QUOTA", ei.Reason)
+	qf, ok := QuotaFailure(ec)
+	assert.True(t, ok)
+	assert.Equal(t, "user:2233", qf.Violations[0].Subject)
+	_, ok = BadRequest(ec)
+	assert.False(t, ok)
+}
+
+func TestToMetaCodeWithStandardDetails(t *testing.T) {
+	// 非metacode服务端只返回标准错误详情
+	gst, _ := status.New(codes.NotFound, "user not found").WithDetails(&errdetails.ErrorInfo{Reason: "USER_NOT_FOUND"})
+	ec := ToMetaCode(gst)
+	assert.Equal(t, metacode.NothingFound.Code(), ec.Code())
+	assert.Equal(t, "user not found", ec.Message())
+	ei, ok := ErrorInfo(ec)
+	assert.True(t, ok)
+	assert.Equal(t, "USER_NOT_FOUND", ei.Reason)
+
+	ei, ok = ErrorInfo(gst.Err())
+	assert.True(t, ok)
+	assert.Equal(t, "USER_NOT_FOUND", ei.Reason)
+}
+
+func TestClientRetryInfo(t *testing.T) {
+	var count int32
+	cli, cancel := NewTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			return nil, NewErrorBuilder(metacode.ServiceUnavailable, "busy").WithRetryInfo(time.Millisecond * 10).Err()
+		}
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second), Retry: 2})
+	defer cancel()
+
+	reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "retry"})
+	assert.Nil(t, err)
+	assert.True(t, reply.Success)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+
+	// 超过最大重试次数后返回最后一次的错误
+	atomic.StoreInt32(&count, -10)
+	_, err = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "retry"})
+	assert.True(t, metacode.EqualError(metacode.ServiceUnavailable, err))
+	assert.Equal(t, int32(-7), atomic.LoadInt32(&count))
+}
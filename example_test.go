@@ -43,7 +43,7 @@ const systemId = "10000"
 
 func ExampleServer() {
 	conf := configuration.DefaultEngine()
-	s, _ := rpc.Engine(systemId, conf).Server("base", "app", systemId)
+	s, _ := rpc.Engine(systemId, conf).Server(false, "base", "app", systemId)
 	// apply server interceptor middleware
 	s.Use(func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		_ctx, cancel := context.WithTimeout(ctx, time.Second*10)
@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+)
+
+// FaultConfig 故障注入配置,用于测试调用方的容错能力,为空时不注入任何故障.
+type FaultConfig struct {
+	Rules []*FaultRule `json:"rules"` // 故障注入规则,按顺序使用第一条匹配的规则
+}
+
+// FaultRule 故障注入规则
+type FaultRule struct {
+	Method     string         `json:"method"`     // 方法全名,以*结尾时按照前缀匹配,为空时匹配所有方法
+	Caller     string         `json:"caller"`     // 调用方,只在服务端生效,为空时匹配所有调用方
+	Percentage float64        `json:"percentage"` // 注入故障的请求百分比,取值范围为(0,100]
+	Delay      utils.Duration `json:"delay"`      // 注入的延迟
+	Code       int            `json:"code"`       // 注入的错误码,为零时只注入延迟
+	Abort      bool           `json:"abort"`      // 为true时先执行请求再丢弃响应返回错误码,用于模拟请求已生效但响应丢失的情况
+}
+
+// match 返回匹配请求的规则,并按照百分比决定是否注入
+func (c *FaultConfig) match(method, caller string) *FaultRule {
+	for _, r := range c.Rules {
+		if !matchMethod(r.Method, method) {
+			continue
+		}
+		if r.Caller != "" && r.Caller != caller {
+			continue
+		}
+		if rand.Float64()*100 < r.Percentage {
+			return r
+		}
+		return nil
+	}
+	return nil
+}
+
+// kind 返回故障类型,用于监控
+func (r *FaultRule) kind() string {
+	switch {
+	case r.Code != 0 && r.Abort:
+		return "abort"
+	case r.Code != 0:
+		return "error"
+	}
+	return "delay"
+}
+
+// apply 按照规则注入延迟以及错误,invoke执行实际的请求
+func (r *FaultRule) apply(ctx context.Context, invoke func() error) error {
+	if r.Delay > 0 {
+		timer := time.NewTimer(time.Duration(r.Delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if r.Code == 0 {
+		return invoke()
+	}
+	if r.Abort {
+		_ = invoke()
+	}
+	return metacode.Code(r.Code)
+}
+
+// fault 返回故障注入的服务器拦截器.
+func (s *Server) fault() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		s.mutex.RLock()
+		conf := s.conf.Fault
+		s.mutex.RUnlock()
+		if conf == nil {
+			return handler(ctx, req)
+		}
+		rule := conf.match(args.FullMethod, metacode.ToString(ctx, metacode.Caller))
+		if rule == nil {
+			return handler(ctx, req)
+		}
+		metricServerFaultTotal.Inc(args.FullMethod, rule.kind())
+		err = rule.apply(ctx, func() (err error) {
+			resp, err = handler(ctx, req)
+			return
+		})
+		if err != nil {
+			resp = nil
+		}
+		return
+	}
+}
+
+// fault 返回故障注入的客户端拦截器,在每次尝试中生效,因此可以用于测试重试以及对冲.
+func (c *Client) fault() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c.mutex.RLock()
+		conf := c.conf.Fault
+		c.mutex.RUnlock()
+		if conf == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		rule := conf.match(method, "")
+		if rule == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		metricClientFaultTotal.Inc(method, rule.kind())
+		return rule.apply(ctx, func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
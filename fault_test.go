@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultMatch(t *testing.T) {
+	conf := &FaultConfig{Rules: []*FaultRule{
+		{Method: "/testproto.Greeter/SayHello", Caller: "1001", Percentage: 100, Code: -500},
+		{Method: "/testproto.*", Percentage: 100, Delay: utils.Duration(time.Millisecond)},
+		{Percentage: 0, Code: -503},
+	}}
+	assert.Equal(t, conf.Rules[0], conf.match("/testproto.Greeter/SayHello", "1001"))
+	assert.Equal(t, conf.Rules[1], conf.match("/testproto.Greeter/SayHello", "1002"))
+	assert.Nil(t, conf.match("/other.Greeter/SayHello", "1001"))
+}
+
+func TestFaultInjection(t *testing.T) {
+	var count int32
+	srv, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&count, 1)
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	defer cancel()
+	setFault := func(rule *FaultRule) {
+		conf := *srv.conf
+		conf.Fault = &FaultConfig{Rules: []*FaultRule{rule}}
+		_ = srv.SetConfig(&conf)
+		atomic.StoreInt32(&count, 0)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		setFault(&FaultRule{Percentage: 100, Code: metacode.ServiceUnavailable.Code()})
+		_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fault"})
+		assert.True(t, metacode.EqualError(metacode.ServiceUnavailable, err))
+		assert.Equal(t, int32(0), atomic.LoadInt32(&count))
+	})
+	t.Run("abort", func(t *testing.T) {
+		setFault(&FaultRule{Percentage: 100, Code: metacode.ServerErr.Code(), Abort: true})
+		_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fault"})
+		assert.True(t, metacode.EqualError(metacode.ServerErr, err))
+		// 请求已执行,只是丢弃了响应
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+	t.Run("delay", func(t *testing.T) {
+		setFault(&FaultRule{Method: "/testproto.Greeter/*", Percentage: 100, Delay: utils.Duration(time.Millisecond * 50)})
+		start := time.Now()
+		reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fault"})
+		assert.Nil(t, err)
+		assert.True(t, reply.Success)
+		assert.True(t, time.Since(start) >= time.Millisecond*50)
+	})
+	t.Run("caller mismatch", func(t *testing.T) {
+		setFault(&FaultRule{Caller: "9999", Percentage: 100, Code: metacode.ServerErr.Code()})
+		_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fault"})
+		assert.Nil(t, err)
+	})
+}
+
+func TestClientFault(t *testing.T) {
+	var count int32
+	cli, cancel := NewTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&count, 1)
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second), Fault: &FaultConfig{Rules: []*FaultRule{
+			{Method: "/testproto.Greeter/SayHello", Percentage: 100, Code: metacode.Deadline.Code()},
+		}}})
+	defer cancel()
+
+	_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fault"})
+	assert.True(t, metacode.EqualError(metacode.Deadline, err))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&count))
+}
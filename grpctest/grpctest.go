@@ -0,0 +1,163 @@
+// Package grpctest 提供基于bufconn的进程内测试工具,服务器与客户端使用与线上相同的默认拦截器,
+// 不需要监听真实的网络端口.
+//
+//	h := grpctest.New(t, func(s *grpc.Server) {
+//		pb.RegisterGreeterServer(s, &greeter{})
+//	}, grpctest.WithCaller("1001"))
+//	reply, err := pb.NewGreeterClient(h.Conn).SayHello(ctx, &pb.HelloRequest{Name: "aluka"})
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	rpc "github.com/aluka-7/grpc"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/trace"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	_bufSize         = 1024 * 1024
+	_target          = "bufnet"
+	_shutdownTimeout = time.Second * 5
+)
+
+// Option 测试工具的配置项
+type Option func(*options)
+
+type options struct {
+	server         *rpc.ServerConfig
+	client         *rpc.ClientConfig
+	caller         []string
+	md             metacode.Metadata
+	trace          trace.Trace
+	serverHandlers []grpc.UnaryServerInterceptor
+	clientHandlers []grpc.UnaryClientInterceptor
+}
+
+// WithServerConfig 使用指定的服务器配置,默认超时时间为1s.
+func WithServerConfig(conf *rpc.ServerConfig) Option {
+	return func(o *options) { o.server = conf }
+}
+
+// WithClientConfig 使用指定的客户端配置,默认超时时间为1s.
+func WithClientConfig(conf *rpc.ClientConfig) Option {
+	return func(o *options) { o.client = conf }
+}
+
+// WithCaller 设置客户端的调用方,服务端通过metacode.Caller获取,默认为10000.
+func WithCaller(caller ...string) Option {
+	return func(o *options) { o.caller = caller }
+}
+
+// WithMetadata 为每次调用注入元数据,调用的上下文中已有的元数据优先,
+// 只有需要传递的元数据(例如metacode.RemoteIP、metacode.Criticality)会发送到服务端.
+func WithMetadata(md metacode.Metadata) Option {
+	return func(o *options) { o.md = metacode.Join(o.md, md) }
+}
+
+// WithTrace 为上下文中没有链路追踪的调用注入t.
+func WithTrace(t trace.Trace) Option {
+	return func(o *options) { o.trace = t }
+}
+
+// WithServerInterceptors 为服务器添加拦截器.
+func WithServerInterceptors(handlers ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) { o.serverHandlers = append(o.serverHandlers, handlers...) }
+}
+
+// WithClientInterceptors 为客户端添加拦截器.
+func WithClientInterceptors(handlers ...grpc.UnaryClientInterceptor) Option {
+	return func(o *options) { o.clientHandlers = append(o.clientHandlers, handlers...) }
+}
+
+// Harness 运行在bufconn上的服务器以及连接到该服务器的客户端
+type Harness struct {
+	Server *rpc.Server
+	Client *rpc.Client
+	Conn   *grpc.ClientConn
+
+	listener *bufconn.Listener
+}
+
+// New 创建服务器并调用register注册服务,之后使用客户端连接到服务器,测试结束时通过t.Cleanup关闭连接以及服务器.
+func New(t testing.TB, register func(s *grpc.Server), opts ...Option) *Harness {
+	t.Helper()
+	o := &options{
+		server: &rpc.ServerConfig{Timeout: utils.Duration(time.Second)},
+		client: &rpc.ClientConfig{Dial: utils.Duration(time.Second * 5), Timeout: utils.Duration(time.Second)},
+		caller: []string{"10000"},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	h := &Harness{listener: bufconn.Listen(_bufSize)}
+	h.Server = rpc.NewServer(o.server)
+	if len(o.serverHandlers) > 0 {
+		h.Server.Use(o.serverHandlers...)
+	}
+	register(h.Server.Server())
+	go func() {
+		_ = h.Server.Serve(h.listener)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), _shutdownTimeout)
+		defer cancel()
+		_ = h.Server.Shutdown(ctx)
+		_ = h.listener.Close()
+	})
+
+	h.Client = rpc.NewClient(o.client, grpc.WithContextDialer(h.dialer))
+	h.Client.Use(inject(o))
+	if len(o.clientHandlers) > 0 {
+		h.Client.Use(o.clientHandlers...)
+	}
+	conn, err := h.Client.Dial(context.Background(), _target, o.caller)
+	if err != nil {
+		t.Fatalf("grpctest: dial bufconn error: %+v", err)
+	}
+	h.Conn = conn
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return h
+}
+
+// Dial 使用指定的客户端配置创建一个新的连接,测试结束时自动关闭.
+func (h *Harness) Dial(t testing.TB, conf *rpc.ClientConfig, caller ...string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := rpc.NewClient(conf, grpc.WithContextDialer(h.dialer)).Dial(context.Background(), _target, caller)
+	if err != nil {
+		t.Fatalf("grpctest: dial bufconn error: %+v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func (h *Harness) dialer(context.Context, string) (net.Conn, error) {
+	return h.listener.Dial()
+}
+
+// inject 返回注入元数据以及链路追踪的客户端拦截器
+func inject(o *options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if len(o.md) > 0 {
+			md, _ := metacode.FromContext(ctx)
+			ctx = metacode.NewContext(ctx, metacode.Join(o.md, md))
+		}
+		if o.trace != nil {
+			if _, ok := trace.FromContext(ctx); !ok {
+				ctx = trace.NewContext(ctx, o.trace)
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,45 @@
+package grpctest
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/trace"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type greeter struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (g *greeter) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	var traceId string
+	if t, ok := trace.FromContext(ctx); ok {
+		traceId = t.TraceId()
+	}
+	return &pb.HelloReply{Message: metacode.ToString(ctx, metacode.Caller) + "|" + metacode.ToString(ctx, metacode.RemoteIP) + "|" + traceId, Success: true}, nil
+}
+
+func TestHarness(t *testing.T) {
+	tr := trace.New("grpctest")
+	h := New(t, func(s *grpc.Server) {
+		pb.RegisterGreeterServer(s, &greeter{})
+	}, WithCaller("1001"), WithMetadata(metacode.Metadata{metacode.RemoteIP: "2.2.3.3"}), WithTrace(tr))
+
+	reply, err := pb.NewGreeterClient(h.Conn).SayHello(context.Background(), &pb.HelloRequest{Name: "aluka"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1001|2.2.3.3|"+tr.TraceId(), reply.Message)
+
+	// 上下文中的元数据优先
+	ctx := metacode.NewContext(context.Background(), metacode.Metadata{metacode.RemoteIP: "1.1.1.1"})
+	reply, err = pb.NewGreeterClient(h.Conn).SayHello(ctx, &pb.HelloRequest{Name: "aluka"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1001|1.1.1.1|"+tr.TraceId(), reply.Message)
+
+	// 使用与线上相同的默认拦截器,参数校验失败返回ValidateErr
+	_, err = pb.NewGreeterClient(h.Conn).SayHello(context.Background(), &pb.HelloRequest{})
+	assert.True(t, metacode.EqualError(metacode.ValidateErr, err))
+}
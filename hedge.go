@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// HedgePolicy 对冲请求配置,只对幂等的方法生效.
+// 在Delay时间内没有收到响应时会再发起一次相同的请求,采用最先成功的响应并取消其余请求.
+type HedgePolicy struct {
+	Delay      utils.Duration `json:"delay"`      // 发起下一次对冲请求之前等待的时间
+	MaxHedges  int            `json:"maxHedges"`  // 除首次请求外最多发起的对冲请求数量
+	Idempotent bool           `json:"idempotent"` // 方法是否幂等,非幂等方法不会发起对冲请求
+}
+
+type hedgeResult struct {
+	index int
+	reply proto.Message
+	err   error
+}
+
+// isFatal 判断错误是否为确定的结果,确定的结果不需要等待其他对冲请求
+func isFatal(err error) bool {
+	switch metacode.Cause(err).Code() {
+	case metacode.ServiceUnavailable.Code(), metacode.Deadline.Code(), metacode.LimitExceed.Code(), metacode.ServerErr.Code():
+		return false
+	}
+	return true
+}
+
+// hedge 返回对冲请求的客户端拦截器.
+func (c *Client) hedge() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p := c.methodConfig(method).Hedge
+		msg, ok := reply.(proto.Message)
+		if p == nil || !p.Idempotent || p.MaxHedges <= 0 || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// 返回时取消其余未完成的请求
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		results := make(chan hedgeResult, p.MaxHedges+1)
+		var launched, pending int
+		attempt := func() {
+			index := launched
+			r := proto.Clone(msg)
+			r.Reset()
+			launched++
+			pending++
+			// 每次请求使用独立的选项切片,避免后续拦截器追加选项时并发写入同一个底层数组
+			o := append([]grpc.CallOption(nil), opts...)
+			go func() {
+				results <- hedgeResult{index: index, reply: r, err: invoker(ctx, method, req, r, cc, o...)}
+			}()
+		}
+		delay := time.Duration(p.Delay)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		attempt()
+
+		var err error
+		for {
+			select {
+			case r := <-results:
+				pending--
+				if r.err == nil {
+					msg.Reset()
+					proto.Merge(msg, r.reply)
+					metricClientHedgeWinTotal.Inc(method, strconv.Itoa(r.index))
+					return nil
+				}
+				if isFatal(r.err) {
+					return r.err
+				}
+				if err == nil {
+					err = r.err
+				}
+				if pending == 0 {
+					if launched > p.MaxHedges {
+						return err
+					}
+					// 所有请求都已失败时立即发起下一次对冲请求
+					attempt()
+					timer.Reset(delay)
+				}
+			case <-timer.C:
+				if launched <= p.MaxHedges {
+					attempt()
+					timer.Reset(delay)
+				}
+			}
+		}
+	}
+}
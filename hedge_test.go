@@ -0,0 +1,60 @@
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedge(t *testing.T) {
+	var count int32
+	canceled := make(chan struct{}, 1)
+	method := "/testproto.Greeter/SayHello"
+	cli, cancel := NewTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		n := atomic.AddInt32(&count, 1)
+		if req.Name == "fatal" {
+			return nil, metacode.NothingFound
+		}
+		if n == 1 {
+			// 首次请求很慢,应该被对冲请求取代并被取消
+			select {
+			case <-ctx.Done():
+				canceled <- struct{}{}
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return &pb.HelloReply{Message: "Hello " + req.Name, Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second * 2)}, &ClientConfig{
+		Dial:    utils.Duration(time.Second * 10),
+		Timeout: utils.Duration(time.Second * 2),
+		Method: map[string]*ClientConfig{method: {
+			Timeout: utils.Duration(time.Second * 2),
+			Hedge:   &HedgePolicy{Delay: utils.Duration(time.Millisecond * 20), MaxHedges: 1, Idempotent: true},
+		}},
+	})
+	defer cancel()
+
+	start := time.Now()
+	reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "hedge"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello hedge", reply.Message)
+	assert.True(t, time.Since(start) < time.Millisecond*500)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow attempt should be canceled")
+	}
+
+	// 确定的错误直接返回,不会发起对冲请求
+	atomic.StoreInt32(&count, 10)
+	_, err = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "fatal"})
+	assert.True(t, metacode.EqualError(metacode.NothingFound, err))
+	assert.Equal(t, int32(11), atomic.LoadInt32(&count))
+}
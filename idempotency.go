@@ -0,0 +1,282 @@
+package grpc
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/rs/zerolog/log"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IdempotencyKey 幂等键的元数据名称,服务端对携带相同幂等键的请求只执行一次.
+const IdempotencyKey = "x-idempotency-key"
+
+const (
+	_defaultIdempotencyTTL  = time.Hour * 24
+	_defaultIdempotencySize = 10000
+)
+
+// IdempotencyConfig 服务端幂等配置,为空时不处理幂等键.
+type IdempotencyConfig struct {
+	TTL  utils.Duration `json:"ttl"`  // 执行结果的保存时间,默认值为 24h
+	Size int            `json:"size"` // 默认内存存储最多保存的执行结果数量,默认值为 10000
+}
+
+// IdempotencyStore 保存幂等请求执行结果的存储,可以替换为redis等共享存储以支持多实例部署.
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// IdempotencyCallOption 为请求指定幂等键.
+type IdempotencyCallOption struct {
+	*grpc.EmptyCallOption
+	Key string
+}
+
+// WithIdempotencyKey 为请求指定幂等键,未指定时客户端只为允许重试的请求自动生成幂等键.
+func WithIdempotencyKey(key string) *IdempotencyCallOption {
+	return &IdempotencyCallOption{&grpc.EmptyCallOption{}, key}
+}
+
+// newIdempotencyKey 生成随机的幂等键
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// idempotency 返回附加幂等键的客户端拦截器,必须位于重试拦截器之前,以保证每次重试使用相同的幂等键.
+// 对冲的请求不自动生成幂等键,否则服务端会让对冲请求等待首次请求的结果,对冲失去作用.
+func (c *Client) idempotency() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var key string
+		for _, opt := range opts {
+			if o, ok := opt.(*IdempotencyCallOption); ok {
+				key = o.Key
+				break
+			}
+		}
+		if key == "" {
+			if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(IdempotencyKey)) > 0 {
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+			if conf := c.methodConfig(method); conf.Retry > 0 {
+				key = newIdempotencyKey()
+			}
+		}
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, IdempotencyKey, key)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+type idempotentCall struct {
+	done chan struct{}
+	resp interface{}
+	err  error
+}
+
+// idempotent 服务端幂等处理的状态
+type idempotent struct {
+	mutex sync.Mutex
+	store IdempotencyStore
+	calls map[string]*idempotentCall
+}
+
+// SetIdempotencyStore 替换默认的内存幂等存储.
+func (s *Server) SetIdempotencyStore(store IdempotencyStore) *Server {
+	s.idempotent.mutex.Lock()
+	s.idempotent.store = store
+	s.idempotent.mutex.Unlock()
+	return s
+}
+
+// begin 开始执行幂等请求,相同幂等键的请求正在执行时返回该请求以等待其结果
+func (i *idempotent) begin(key string, conf *IdempotencyConfig) (IdempotencyStore, *idempotentCall, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.store == nil {
+		size := conf.Size
+		if size <= 0 {
+			size = _defaultIdempotencySize
+		}
+		i.store = NewMemoryStore(size)
+	}
+	if c, ok := i.calls[key]; ok {
+		return i.store, c, false
+	}
+	if i.calls == nil {
+		i.calls = make(map[string]*idempotentCall)
+	}
+	c := &idempotentCall{done: make(chan struct{})}
+	i.calls[key] = c
+	return i.store, c, true
+}
+
+func (i *idempotent) end(key string, c *idempotentCall) {
+	i.mutex.Lock()
+	delete(i.calls, key)
+	i.mutex.Unlock()
+	close(c.done)
+}
+
+// encodeResult 将执行结果编码为google.rpc.Status,成功的响应保存在details中
+func encodeResult(resp interface{}, err error) ([]byte, bool) {
+	var st *spb.Status
+	if err != nil {
+		st = FromError(err).Proto()
+	} else {
+		msg, ok := resp.(proto.Message)
+		if !ok {
+			return nil, false
+		}
+		a, err := ptypes.MarshalAny(msg)
+		if err != nil {
+			return nil, false
+		}
+		st = &spb.Status{Details: []*any.Any{a}}
+	}
+	b, err := proto.Marshal(st)
+	return b, err == nil
+}
+
+// decodeResult 解码保存的执行结果,ok为false表示结果无法解码
+func decodeResult(b []byte) (resp interface{}, err error, ok bool) {
+	st := new(spb.Status)
+	if proto.Unmarshal(b, st) != nil {
+		return nil, nil, false
+	}
+	if st.Code != 0 {
+		return nil, ToMetaCode(status.FromProto(st)), true
+	}
+	if len(st.Details) == 0 {
+		return nil, nil, false
+	}
+	msg, derr := ptypes.Empty(st.Details[0])
+	if derr != nil || ptypes.UnmarshalAny(st.Details[0], msg) != nil {
+		return nil, nil, false
+	}
+	return msg, nil, true
+}
+
+// idempotency 返回幂等处理的服务器拦截器,按照调用方、方法以及幂等键对请求去重,重复的请求直接返回保存的响应或者错误.
+// 可重试的错误(参见isFatal)不会被保存,以便客户端重试时重新执行.
+func (s *Server) idempotency() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		s.mutex.RLock()
+		conf := s.conf.Idempotency
+		s.mutex.RUnlock()
+		if conf == nil {
+			return handler(ctx, req)
+		}
+		gmd, _ := metadata.FromIncomingContext(ctx)
+		keys := gmd.Get(IdempotencyKey)
+		if len(keys) == 0 || keys[0] == "" {
+			return handler(ctx, req)
+		}
+		key := metacode.ToString(ctx, metacode.Caller) + "|" + args.FullMethod + "|" + keys[0]
+		store, call, first := s.idempotent.begin(key, conf)
+		if !first {
+			// 相同幂等键的请求正在执行,等待其结果
+			select {
+			case <-call.done:
+				metricServerIdempotentReplayTotal.Inc(args.FullMethod)
+				return call.resp, call.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		defer s.idempotent.end(key, call)
+		if b, ok, err := store.Get(ctx, key); err != nil {
+			log.Warn().Err(err).Str("method", args.FullMethod).Msg("rpc idempotency store get failed")
+		} else if ok {
+			if call.resp, call.err, ok = decodeResult(b); ok {
+				metricServerIdempotentReplayTotal.Inc(args.FullMethod)
+				return call.resp, call.err
+			}
+		}
+		call.resp, call.err = handler(ctx, req)
+		if call.err != nil && !isFatal(call.err) {
+			return call.resp, call.err
+		}
+		if b, ok := encodeResult(call.resp, call.err); ok {
+			ttl := time.Duration(conf.TTL)
+			if ttl <= 0 {
+				ttl = _defaultIdempotencyTTL
+			}
+			if err := store.Set(ctx, key, b, ttl); err != nil {
+				log.Warn().Err(err).Str("method", args.FullMethod).Msg("rpc idempotency store set failed")
+			}
+		}
+		return call.resp, call.err
+	}
+}
+
+type memoryEntry struct {
+	key    string
+	value  []byte
+	expire time.Time
+}
+
+// memoryStore 带过期时间的LRU内存存储
+type memoryStore struct {
+	mutex sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewMemoryStore 创建最多保存size个执行结果的内存幂等存储.
+func NewMemoryStore(size int) IdempotencyStore {
+	return &memoryStore{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (m *memoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := e.Value.(*memoryEntry)
+	if time.Now().After(entry.expire) {
+		m.ll.Remove(e)
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	m.ll.MoveToFront(e)
+	return entry.value, true, nil
+}
+
+func (m *memoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expire := time.Now().Add(ttl)
+	if e, ok := m.items[key]; ok {
+		entry := e.Value.(*memoryEntry)
+		entry.value, entry.expire = value, expire
+		m.ll.MoveToFront(e)
+		return nil
+	}
+	m.items[key] = m.ll.PushFront(&memoryEntry{key: key, value: value, expire: expire})
+	for m.ll.Len() > m.size {
+		e := m.ll.Back()
+		m.ll.Remove(e)
+		delete(m.items, e.Value.(*memoryEntry).key)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestIdempotency(t *testing.T) {
+	var count int32
+	_, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		n := atomic.AddInt32(&count, 1)
+		switch req.Name {
+		case "conflict":
+			return nil, metacode.Conflict
+		case "busy":
+			if n%2 == 1 {
+				return nil, NewErrorBuilder(metacode.ServiceUnavailable, "busy").WithRetryInfo(time.Millisecond).Err()
+			}
+		}
+		return &pb.HelloReply{Success: true, Message: strconv.Itoa(int(n))}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second), Idempotency: &IdempotencyConfig{}},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	defer cancel()
+
+	t.Run("replay response", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		for i := 0; i < 3; i++ {
+			reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "pay"}, WithIdempotencyKey("k1"))
+			assert.Nil(t, err)
+			assert.Equal(t, "1", reply.Message)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+		// 没有幂等键的请求每次都会执行
+		reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "pay"})
+		assert.Nil(t, err)
+		assert.Equal(t, "2", reply.Message)
+	})
+	t.Run("replay error", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		for i := 0; i < 2; i++ {
+			_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "conflict"}, WithIdempotencyKey("k2"))
+			assert.True(t, metacode.EqualError(metacode.Conflict, err))
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+	t.Run("retryable error", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "busy"}, WithIdempotencyKey("k3"))
+		assert.True(t, metacode.EqualError(metacode.ServiceUnavailable, err))
+		// 可重试的错误不会被保存
+		reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "busy"}, WithIdempotencyKey("k3"))
+		assert.Nil(t, err)
+		assert.Equal(t, "2", reply.Message)
+	})
+}
+
+func TestIdempotencyAutoKey(t *testing.T) {
+	var count int32
+	var keys []string
+	_, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		keys = append(keys, md.Get(IdempotencyKey)...)
+		if atomic.AddInt32(&count, 1) == 1 {
+			return nil, NewErrorBuilder(metacode.ServiceUnavailable, "busy").WithRetryInfo(time.Millisecond).Err()
+		}
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second), Retry: 1})
+	defer cancel()
+
+	_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "retry"})
+	assert.Nil(t, err)
+	// 重试时使用相同的幂等键
+	assert.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+}
+
+func TestIdempotencyHedgeNoKey(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		keys  []string
+		calls int
+	)
+	_, cli, cancel := newTestServerClient(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		mutex.Lock()
+		keys = append(keys, md.Get(IdempotencyKey)...)
+		calls++
+		first := calls == 1
+		mutex.Unlock()
+		if first {
+			// 第一次调用较慢,触发对冲的请求
+			time.Sleep(time.Millisecond * 100)
+		}
+		return &pb.HelloReply{Success: true}, nil
+	}, &ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second), Idempotency: &IdempotencyConfig{}},
+		&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second),
+			Hedge: &HedgePolicy{Delay: utils.Duration(time.Millisecond * 50), MaxHedges: 1, Idempotent: true}})
+	defer cancel()
+
+	_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "hedge"})
+	assert.Nil(t, err)
+	// 对冲的请求不自动生成幂等键
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls == 2
+	}, time.Second, time.Millisecond*10)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Empty(t, keys)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(2)
+	_ = store.Set(ctx, "a", []byte("a"), time.Minute)
+	_ = store.Set(ctx, "b", []byte("b"), time.Minute)
+	_, _, _ = store.Get(ctx, "a")
+	_ = store.Set(ctx, "c", []byte("c"), time.Minute)
+	// 淘汰最久未使用的b
+	_, ok, _ := store.Get(ctx, "b")
+	assert.False(t, ok)
+	v, ok, _ := store.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), v)
+
+	_ = store.Set(ctx, "d", []byte("d"), time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	_, ok, _ = store.Get(ctx, "d")
+	assert.False(t, ok)
+}
@@ -0,0 +1,153 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// 等待客户端请求完成时的检查间隔
+const _drainInterval = time.Millisecond * 10
+
+// track 返回统计客户端未完成请求数量的拦截器,用于关闭连接前等待请求完成
+func (c *Client) track() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		atomic.AddInt64(&c.inflight, 1)
+		defer atomic.AddInt64(&c.inflight, -1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Drain 等待客户端所有未完成的请求结束,ctx结束时返回ctx的错误.
+func (c *Client) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(_drainInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&c.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// engineServer 引擎创建的服务器
+type engineServer struct {
+	server  *Server
+	started bool
+}
+
+// engineConn 引擎创建的客户端连接
+type engineConn struct {
+	ccc    *clientConfigChanged
+	client *Client
+	conn   *grpc.ClientConn
+	refs   int
+	ready  chan struct{} // 连接创建完成后关闭
+	err    error
+}
+
+// close 关闭连接以及流量镜像、流量拆分的连接,并停止应用配置更新
+func (ec *engineConn) close() error {
+	ec.ccc.stop()
+	err := ec.conn.Close()
+	if e := ec.client.SetMirror(nil); err == nil {
+		err = e
+	}
+	if e := ec.client.SetTargets(nil); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (r *rpcEngine) addServer(s *Server) {
+	r.mutex.Lock()
+	r.servers = append(r.servers, &engineServer{server: s})
+	r.mutex.Unlock()
+}
+
+// Start 启动引擎创建的所有尚未启动的服务器.
+func (r *rpcEngine) Start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, s := range r.servers {
+		if s.started {
+			continue
+		}
+		if _, err := s.server.Start(); err != nil {
+			return err
+		}
+		s.started = true
+	}
+	return nil
+}
+
+// Shutdown 按顺序关闭引擎创建的服务器以及客户端连接:先优雅停止所有服务器并等待未完成的请求结束,
+// 再等待客户端未完成的请求结束并关闭连接,ctx结束时强制关闭,返回遇到的第一个错误.
+func (r *rpcEngine) Shutdown(ctx context.Context) (err error) {
+	r.mutex.Lock()
+	servers, conns := r.servers, r.conns
+	r.servers, r.conns, r.shutdown = nil, nil, true
+	r.mutex.Unlock()
+
+	var once sync.Once
+	setErr := func(e error) {
+		if e != nil {
+			once.Do(func() { err = e })
+		}
+	}
+	// 服务器处理请求时可能调用下游服务,因此先停止服务器
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *Server) {
+			defer wg.Done()
+			setErr(s.Shutdown(ctx))
+			releaseMetricTags(s)
+		}(s.server)
+	}
+	wg.Wait()
+	for _, c := range conns {
+		<-c.ready
+		if c.err == nil {
+			setErr(c.client.Drain(ctx))
+		}
+	}
+	for _, c := range conns {
+		if c.err == nil {
+			setErr(c.close())
+		}
+	}
+	return
+}
+
+// Run 启动所有服务器并阻塞到收到退出信号,之后在timeout时间内关闭引擎.
+func (r *rpcEngine) Run(timeout time.Duration) error {
+	if err := r.Start(); err != nil {
+		return err
+	}
+	sig := WaitForSignal()
+	fmt.Printf("收到信号[%s],开始关闭RPC引擎\n", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return r.Shutdown(ctx)
+}
+
+// WaitForSignal 阻塞到收到指定的信号并返回该信号,未指定时等待SIGINT、SIGTERM以及SIGQUIT.
+func WaitForSignal(signals ...os.Signal) os.Signal {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+	return <-ch
+}
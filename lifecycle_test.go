@@ -0,0 +1,20 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientDrain(t *testing.T) {
+	c := NewClient(&ClientConfig{Timeout: utils.Duration(time.Second)})
+	c.inflight = 1
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.Drain(ctx))
+	c.inflight = 0
+	assert.Nil(t, c.Drain(context.Background()))
+}
@@ -0,0 +1,228 @@
+package grpc
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+)
+
+// 请求的优先级,通过 metacode.Criticality 元数据传递,未设置时为 Critical.
+// 超出并发上限时低优先级的请求优先被拒绝.
+const (
+	CriticalPlus  = "critical_plus"
+	Critical      = "critical"
+	SheddablePlus = "sheddable_plus"
+	Sheddable     = "sheddable"
+)
+
+// 并发限制类型
+const (
+	LimiterStatic   = "static"   // 固定的并发上限
+	LimiterGradient = "gradient" // 根据响应时间的变化自适应调整并发上限
+)
+
+var _priorities = []string{Sheddable, SheddablePlus, Critical, CriticalPlus}
+
+// LimiterConfig 服务端并发限制配置
+type LimiterConfig struct {
+	Type         string         `json:"type"`         // 并发限制类型,static 或 gradient,默认值为 static
+	Limit        int            `json:"limit"`        // 并发上限,gradient 类型时为初始值
+	MinLimit     int            `json:"minLimit"`     // gradient 类型的并发下限,默认值为 1
+	MaxLimit     int            `json:"maxLimit"`     // gradient 类型的并发上限,默认值为 Limit 的 10 倍
+	QueueSize    int            `json:"queueSize"`    // 超出并发上限后排队等待的请求数量,为零时直接拒绝
+	QueueTimeout utils.Duration `json:"queueTimeout"` // 排队等待的最长时间,默认值为 100ms
+}
+
+// priority 返回请求优先级对应的序号,序号越大优先级越高
+func priority(criticality string) int {
+	for i, p := range _priorities {
+		if p == criticality {
+			return i
+		}
+	}
+	return 2 // Critical
+}
+
+type waiter struct {
+	ready chan bool // true表示获得执行机会,false表示被更高优先级的请求挤出队列
+}
+
+// limiter 并发限制器,超出并发上限的请求按照优先级排队,队列满时优先拒绝低优先级的请求
+type limiter struct {
+	mutex    sync.Mutex
+	conf     LimiterConfig
+	limit    float64
+	inflight int
+	queue    [4][]*waiter
+	queued   int
+	longRTT  float64
+	shortRTT float64
+}
+
+// reset 并发限制配置发生变化时重新设置并发上限
+func (l *limiter) reset(conf *LimiterConfig) {
+	if l.conf == *conf {
+		return
+	}
+	l.conf = *conf
+	l.limit = float64(conf.Limit)
+	l.longRTT, l.shortRTT = 0, 0
+}
+
+func (l *limiter) acquire(ctx context.Context, conf *LimiterConfig, pri int) bool {
+	l.mutex.Lock()
+	l.reset(conf)
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		l.mutex.Unlock()
+		return true
+	}
+	if conf.QueueSize <= 0 {
+		l.mutex.Unlock()
+		return false
+	}
+	if l.queued >= conf.QueueSize {
+		// 队列已满时挤出比当前请求优先级低的请求
+		victim := l.popLowest(pri)
+		if victim == nil {
+			l.mutex.Unlock()
+			return false
+		}
+		victim.ready <- false
+	}
+	w := &waiter{ready: make(chan bool, 1)}
+	l.queue[pri] = append(l.queue[pri], w)
+	l.queued++
+	l.metrics()
+	l.mutex.Unlock()
+
+	timeout := time.Millisecond * 100
+	if conf.QueueTimeout > 0 {
+		timeout = time.Duration(conf.QueueTimeout)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-w.ready:
+		return ok
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.mutex.Lock()
+	removed := l.remove(pri, w)
+	l.mutex.Unlock()
+	if removed {
+		return false
+	}
+	// 超时的同时已经获得了执行机会或者被挤出了队列
+	return <-w.ready
+}
+
+func (l *limiter) release(rtt time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conf.Type == LimiterGradient {
+		l.gradient(rtt)
+	}
+	if l.queued > 0 && l.inflight <= int(l.limit) {
+		// 直接将执行机会交给优先级最高的请求
+		l.popHighest().ready <- true
+	} else {
+		l.inflight--
+	}
+	l.metrics()
+}
+
+// gradient 根据长期和短期响应时间的比值调整并发上限:响应时间变长时降低上限,否则缓慢增加上限
+func (l *limiter) gradient(rtt time.Duration) {
+	sample := float64(rtt)
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = sample, sample
+	}
+	l.longRTT = l.longRTT*0.95 + sample*0.05
+	l.shortRTT = l.shortRTT*0.5 + sample*0.5
+	gradient := math.Max(0.5, math.Min(1.0, l.longRTT/l.shortRTT))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	// 并发数没有达到上限的一半时,说明上限不是瓶颈,不继续增加
+	if float64(l.inflight) < l.limit/2 {
+		newLimit = math.Min(newLimit, l.limit)
+	}
+	minLimit, maxLimit := float64(l.conf.MinLimit), float64(l.conf.MaxLimit)
+	if minLimit <= 0 {
+		minLimit = 1
+	}
+	if maxLimit <= 0 {
+		maxLimit = float64(l.conf.Limit * 10)
+	}
+	l.limit = math.Max(minLimit, math.Min(maxLimit, l.limit*0.8+newLimit*0.2))
+}
+
+func (l *limiter) popHighest() *waiter {
+	for i := len(l.queue) - 1; i >= 0; i-- {
+		if len(l.queue[i]) > 0 {
+			w := l.queue[i][0]
+			l.queue[i] = l.queue[i][1:]
+			l.queued--
+			return w
+		}
+	}
+	return nil
+}
+
+func (l *limiter) popLowest(pri int) *waiter {
+	for i := 0; i < pri; i++ {
+		if n := len(l.queue[i]); n > 0 {
+			// 挤出最晚进入队列的请求
+			w := l.queue[i][n-1]
+			l.queue[i] = l.queue[i][:n-1]
+			l.queued--
+			return w
+		}
+	}
+	return nil
+}
+
+func (l *limiter) remove(pri int, w *waiter) bool {
+	for i, v := range l.queue[pri] {
+		if v == w {
+			l.queue[pri] = append(l.queue[pri][:i], l.queue[pri][i+1:]...)
+			l.queued--
+			return true
+		}
+	}
+	return false
+}
+
+func (l *limiter) metrics() {
+	metricServerLimiterInflight.Set(float64(l.inflight))
+	metricServerLimiterQueue.Set(float64(l.queued))
+	metricServerLimiterLimit.Set(l.limit)
+}
+
+// limit 返回并发限制的服务器拦截器,超出并发上限并且无法排队的请求返回 metacode.LimitExceed.
+func (s *Server) limit() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		s.mutex.RLock()
+		conf := s.conf.Limiter
+		s.mutex.RUnlock()
+		if conf == nil || conf.Limit <= 0 {
+			return handler(ctx, req)
+		}
+		criticality := metacode.ToString(ctx, metacode.Criticality)
+		pri := priority(criticality)
+		if !s.limiter.acquire(ctx, conf, pri) {
+			metricServerLimiterShedTotal.Inc(args.FullMethod, _priorities[pri])
+			return nil, metacode.LimitExceed
+		}
+		start := time.Now()
+		defer func() {
+			s.limiter.release(time.Since(start))
+		}()
+		return handler(ctx, req)
+	}
+}
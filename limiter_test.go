@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestLimiterPriority(t *testing.T) {
+	var l limiter
+	conf := &LimiterConfig{Limit: 1, QueueSize: 1, QueueTimeout: utils.Duration(time.Second)}
+	assert.True(t, l.acquire(context.Background(), conf, priority(Critical)))
+	// 并发已满,低优先级请求进入队列
+	shed := make(chan bool)
+	go func() { shed <- l.acquire(context.Background(), conf, priority(Sheddable)) }()
+	time.Sleep(time.Millisecond * 20)
+	// 队列已满,高优先级请求挤出低优先级请求
+	admitted := make(chan bool)
+	go func() { admitted <- l.acquire(context.Background(), conf, priority(CriticalPlus)) }()
+	assert.False(t, <-shed)
+	// 同等优先级的请求无法进入已满的队列
+	assert.False(t, l.acquire(context.Background(), conf, priority(Sheddable)))
+	l.release(time.Millisecond)
+	assert.True(t, <-admitted)
+	l.release(time.Millisecond)
+	assert.Equal(t, 0, l.inflight)
+	assert.Equal(t, 0, l.queued)
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	var l limiter
+	conf := &LimiterConfig{Limit: 1, QueueSize: 1, QueueTimeout: utils.Duration(time.Millisecond * 10)}
+	assert.True(t, l.acquire(context.Background(), conf, priority(Critical)))
+	assert.False(t, l.acquire(context.Background(), conf, priority(Critical)))
+	assert.Equal(t, 0, l.queued)
+	l.release(time.Millisecond)
+	assert.Equal(t, 0, l.inflight)
+}
+
+func TestLimiterGradient(t *testing.T) {
+	var l limiter
+	conf := &LimiterConfig{Type: LimiterGradient, Limit: 10, MinLimit: 2, MaxLimit: 20}
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.acquire(context.Background(), conf, priority(Critical)))
+	}
+	for i := 0; i < 10; i++ {
+		l.release(time.Millisecond)
+		assert.True(t, l.acquire(context.Background(), conf, priority(Critical)))
+	}
+	// 响应时间稳定时并发上限增加
+	assert.Greater(t, l.limit, 10.0)
+	limit := l.limit
+	for i := 0; i < 5; i++ {
+		l.release(time.Millisecond * 100)
+		l.acquire(context.Background(), conf, priority(Critical))
+	}
+	// 响应时间变长时并发上限降低
+	assert.Less(t, l.limit, limit)
+	assert.GreaterOrEqual(t, l.limit, 2.0)
+}
+
+func TestLimitInterceptor(t *testing.T) {
+	s := &Server{conf: &ServerConfig{Limiter: &LimiterConfig{Limit: 1, QueueSize: 1, QueueTimeout: utils.Duration(time.Second)}}}
+	interceptor := s.limit()
+	args := &grpc.UnaryServerInfo{FullMethod: "/testproto.Greeter/SayHello"}
+	block := make(chan struct{})
+	call := func(criticality string, handler grpc.UnaryHandler) <-chan error {
+		ch := make(chan error, 1)
+		go func() {
+			// 优先级与handle()中一样从metacode元数据中读取
+			ctx := metacode.NewContext(context.Background(), metacode.Metadata{metacode.Criticality: criticality})
+			_, err := interceptor(ctx, nil, args, handler)
+			ch <- err
+		}()
+		return ch
+	}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	running := call(Critical, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	time.Sleep(time.Millisecond * 20)
+	sheddable := call(Sheddable, ok)
+	time.Sleep(time.Millisecond * 20)
+	critical := call(Critical, ok)
+
+	// 队列已满时低优先级的请求先被拒绝,高优先级的请求继续排队
+	select {
+	case err := <-sheddable:
+		assert.True(t, metacode.EqualError(metacode.LimitExceed, err))
+	case <-time.After(time.Second):
+		t.Fatal("sheddable request was not rejected")
+	}
+	select {
+	case err := <-critical:
+		t.Fatalf("critical request finished before the running request: %v", err)
+	default:
+	}
+	close(block)
+	assert.Nil(t, <-running)
+	assert.Nil(t, <-critical)
+}
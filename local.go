@@ -0,0 +1,328 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/configuration"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// LocalConfiguration 基于内存、本地文件或者环境变量的配置中心实现,用于本地开发以及测试.
+// 配置项的路径与配置中心相同,例如 /system/base/app/10000,
+// 使用文件时在检测到文件变化后重新加载,并通过 Get 注册的监听器通知发生变化的配置项.
+type LocalConfiguration struct {
+	mutex     sync.RWMutex
+	load      func() (map[string]string, error)
+	key       func(path string) string // 配置项路径在数据中对应的key
+	data      map[string]string
+	listeners []*localListener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type localListener struct {
+	paths    []string
+	listener configuration.ChangedListener
+}
+
+// NewMemoryConfiguration 使用配置项路径到配置内容的映射创建内存配置中心,
+// 通过 Set、Delete 或者 Push 同步通知监听器,便于确定性地测试配置热更新.
+func NewMemoryConfiguration(data map[string]string) *LocalConfiguration {
+	lc := &LocalConfiguration{done: make(chan struct{}), key: func(path string) string { return path }}
+	lc.data = lc.clone()
+	for k, v := range data {
+		lc.data[k] = v
+	}
+	lc.load = func() (map[string]string, error) {
+		lc.mutex.RLock()
+		defer lc.mutex.RUnlock()
+		return lc.clone(), nil
+	}
+	return lc
+}
+
+// NewFileConfiguration 从JSON或者YAML(按照扩展名区分)文件加载配置,文件的内容为配置项路径到配置内容的映射:
+//
+//	/system/base/app/10000:
+//	  network: tcp
+//	  address: 0.0.0.0:9000
+//	  timeout: 1s
+//
+// interval大于零时按照该间隔检测文件的变化.
+func NewFileConfiguration(file string, interval time.Duration) (*LocalConfiguration, error) {
+	var modTime time.Time
+	var size int64
+	lc := &LocalConfiguration{done: make(chan struct{}), key: func(path string) string { return path }, load: func() (map[string]string, error) {
+		return loadConfigFile(file)
+	}}
+	if err := lc.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		if fi, err := os.Stat(file); err == nil {
+			modTime, size = fi.ModTime(), fi.Size()
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-lc.done:
+					return
+				case <-ticker.C:
+				}
+				fi, err := os.Stat(file)
+				if err != nil || (fi.ModTime().Equal(modTime) && fi.Size() == size) {
+					continue
+				}
+				modTime, size = fi.ModTime(), fi.Size()
+				if err = lc.Reload(); err != nil {
+					_, _ = os.Stderr.WriteString("重新加载配置文件[" + file + "]出错:" + err.Error() + "\n")
+				}
+			}
+		}()
+	}
+	return lc, nil
+}
+
+// NewEnvConfiguration 从环境变量加载配置,环境变量的名称为prefix与配置项路径组成的大写字符串,
+// 非字母数字的字符替换为下划线,例如prefix为RPC时 /system/base/app/10000 对应 RPC_SYSTEM_BASE_APP_10000.
+func NewEnvConfiguration(prefix string) *LocalConfiguration {
+	lc := &LocalConfiguration{done: make(chan struct{}), key: func(path string) string { return EnvName(prefix, path) }, load: func() (map[string]string, error) {
+		data := make(map[string]string)
+		for _, kv := range os.Environ() {
+			if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv[:i], prefix) {
+				data[kv[:i]] = kv[i+1:]
+			}
+		}
+		return data, nil
+	}}
+	_ = lc.Reload()
+	return lc
+}
+
+// EnvName 返回配置项路径对应的环境变量名称.
+func EnvName(prefix, path string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range strings.ToUpper(path) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// loadConfigFile 加载配置文件,非字符串的配置内容转换为JSON字符串
+func loadConfigFile(file string) (map[string]string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&raw)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "解析配置文件[%s]出错", file)
+	}
+	data := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			data[k] = s
+			continue
+		}
+		if b, err = json.Marshal(v); err != nil {
+			return nil, errors.Wrapf(err, "配置文件[%s]中的[%s]无法转换为JSON", file, k)
+		}
+		data[k] = string(b)
+	}
+	return data, nil
+}
+
+func (lc *LocalConfiguration) maskPath(app, group, tag, path string) string {
+	key := []string{configuration.Namespace, app, group, path}
+	if len(tag) > 0 {
+		key = []string{configuration.Namespace, app, group, tag, path}
+	}
+	return strings.Join(key, "/")
+}
+
+// Reload 重新加载配置,并通知配置项发生变化的监听器.文件配置会自动检测变化,环境变量配置需要手动调用.
+func (lc *LocalConfiguration) Reload() error {
+	data, err := lc.load()
+	if err != nil {
+		return err
+	}
+	lc.mutex.Lock()
+	old := lc.data
+	lc.data = data
+	listeners := lc.listeners
+	lc.mutex.Unlock()
+	if old == nil {
+		return nil
+	}
+	for _, l := range listeners {
+		changed := false
+		values := make(map[string]string, len(l.paths))
+		for _, p := range l.paths {
+			v, ok := lc.lookup(data, p)
+			if ov, ook := lc.lookup(old, p); ok != ook || v != ov {
+				changed = true
+			}
+			if ok {
+				values[p] = v
+			}
+		}
+		if changed {
+			l.listener.Changed(values)
+		}
+	}
+	return nil
+}
+
+// lookup 查找配置项
+func (lc *LocalConfiguration) lookup(data map[string]string, path string) (string, bool) {
+	v, ok := data[lc.key(path)]
+	return v, ok
+}
+
+func (lc *LocalConfiguration) Values(app, group, tag string, path []string) (map[string]string, error) {
+	lc.mutex.RLock()
+	defer lc.mutex.RUnlock()
+	values := make(map[string]string, len(path))
+	for _, p := range path {
+		key := lc.maskPath(app, group, tag, p)
+		if v, ok := lc.lookup(lc.data, key); ok {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+func (lc *LocalConfiguration) String(app, group, tag, path string) (string, error) {
+	lc.mutex.RLock()
+	defer lc.mutex.RUnlock()
+	key := lc.maskPath(app, group, tag, path)
+	if v, ok := lc.lookup(lc.data, key); ok {
+		return v, nil
+	}
+	return "", errors.Errorf("配置项[%s]不存在", key)
+}
+
+func (lc *LocalConfiguration) Clazz(app, group, tag, path string, clazz interface{}) error {
+	v, err := lc.String(app, group, tag, path)
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(json.Unmarshal([]byte(v), clazz))
+}
+
+// Get 读取配置项并通知监听器,之后配置项发生变化时再次通知.
+func (lc *LocalConfiguration) Get(app, group, tag string, path []string, parser configuration.ChangedListener) {
+	paths := make([]string, len(path))
+	for i, p := range path {
+		paths[i] = lc.maskPath(app, group, tag, p)
+	}
+	values, _ := lc.Values(app, group, tag, path)
+	lc.mutex.Lock()
+	lc.listeners = append(lc.listeners, &localListener{paths: paths, listener: parser})
+	lc.mutex.Unlock()
+	parser.Changed(values)
+}
+
+// Set 更新配置项并同步通知监听该配置项的监听器,文件以及环境变量配置在下一次 Reload 时恢复为加载的内容.
+func (lc *LocalConfiguration) Set(path, value string) {
+	lc.mutex.Lock()
+	data := lc.clone()
+	data[lc.key(path)] = value
+	lc.data = data
+	lc.mutex.Unlock()
+	lc.Push(path)
+}
+
+// Delete 删除配置项并同步通知监听该配置项的监听器.
+func (lc *LocalConfiguration) Delete(path string) {
+	lc.mutex.Lock()
+	data := lc.clone()
+	delete(data, lc.key(path))
+	lc.data = data
+	lc.mutex.Unlock()
+	lc.Push(path)
+}
+
+// Push 在配置项没有变化时同样通知监听该配置项的监听器,模拟配置中心的重复推送.
+func (lc *LocalConfiguration) Push(path string) {
+	lc.mutex.RLock()
+	var notify []*localListener
+	for _, l := range lc.listeners {
+		if l.watch(path) {
+			notify = append(notify, l)
+		}
+	}
+	data := lc.data
+	lc.mutex.RUnlock()
+	for _, l := range notify {
+		values := make(map[string]string, len(l.paths))
+		for _, p := range l.paths {
+			if v, ok := lc.lookup(data, p); ok {
+				values[p] = v
+			}
+		}
+		l.listener.Changed(values)
+	}
+}
+
+// Listeners 返回监听指定配置项的监听器数量.
+func (lc *LocalConfiguration) Listeners(path string) int {
+	lc.mutex.RLock()
+	defer lc.mutex.RUnlock()
+	n := 0
+	for _, l := range lc.listeners {
+		if l.watch(path) {
+			n++
+		}
+	}
+	return n
+}
+
+// clone 复制当前的配置数据,修改配置时替换整个映射,避免与 Reload 中的比较发生竞争
+func (lc *LocalConfiguration) clone() map[string]string {
+	data := make(map[string]string, len(lc.data)+1)
+	for k, v := range lc.data {
+		data[k] = v
+	}
+	return data
+}
+
+// watch 判断监听器是否监听指定的配置项
+func (l *localListener) watch(path string) bool {
+	for _, p := range l.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 停止检测文件的变化.
+func (lc *LocalConfiguration) Close() error {
+	lc.closeOnce.Do(func() {
+		close(lc.done)
+	})
+	return nil
+}
@@ -0,0 +1,65 @@
+package grpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileConfiguration(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rpc.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte(`
+/system/base/app/10000:
+  network: tcp
+  address: 127.0.0.1:0
+  timeout: 1s
+`), 0644))
+	conf, err := NewFileConfiguration(file, time.Millisecond*10)
+	assert.Nil(t, err)
+	defer conf.Close()
+
+	engine := Engine("10000", conf)
+	defer engine.Shutdown(context.Background())
+	s, cfg, err := engine.NewServer(false, "base", "app", "10000")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:0", cfg.Addr)
+
+	// 文件变化后通知监听器
+	assert.Nil(t, os.WriteFile(file, []byte(`
+/system/base/app/10000:
+  network: tcp
+  address: 127.0.0.1:9999
+  timeout: 2s
+`), 0644))
+	assert.Eventually(t, func() bool {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		return s.conf.Addr == "127.0.0.1:9999"
+	}, time.Second, time.Millisecond*10)
+
+	v, err := conf.String("base", "app", "", "10000")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"network":"tcp","address":"127.0.0.1:9999","timeout":"2s"}`, v)
+	_, err = conf.String("base", "app", "", "missing")
+	assert.NotNil(t, err)
+}
+
+func TestEnvConfiguration(t *testing.T) {
+	assert.Equal(t, "RPC_SYSTEM_BASE_RPC_1000", EnvName("RPC", "/system/base/rpc/1000"))
+	t.Setenv("RPC_SYSTEM_BASE_APP_10000", `{"address":"127.0.0.1:0","timeout":"1s"}`)
+	conf := NewEnvConfiguration("RPC")
+	engine := Engine("10000", conf)
+	defer engine.Shutdown(context.Background())
+	s, cfg, err := engine.NewServer(false, "base", "app", "10000")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:0", cfg.Addr)
+	assert.Equal(t, "tcp", cfg.Network)
+
+	t.Setenv("RPC_SYSTEM_BASE_APP_10000", `{"address":"127.0.0.1:9999","timeout":"1s"}`)
+	assert.Nil(t, conf.Reload())
+	assert.Equal(t, "127.0.0.1:9999", s.conf.Addr)
+}
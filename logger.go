@@ -66,12 +66,18 @@ func (s *Server) serverLogging() grpc.UnaryServerInterceptor {
 		// 服务器响应后
 		cause := metacode.Cause(err)
 		dt := time.Since(startTime)
+		s.mutex.RLock()
+		conf := s.conf
+		s.mutex.RUnlock()
+		class := classify(cause.Code(), conf.CodeMetric)
 
 		// 监控
 		metricServerReqDur.Observe(int64(dt/time.Millisecond), info.FullMethod, caller)
-		metricServerReqCodeTotal.Inc(info.FullMethod, caller, strconv.Itoa(cause.Code()))
+		metricServerReqCodeTotal.Inc(info.FullMethod, caller, s.labeler.label(cause.Code(), conf.CodeMetric))
+		metricServerReqClassTotal.Inc(info.FullMethod, caller, class)
+		s.fireErrorHooks(ctx, info.FullMethod, cause, class)
 
-		if s.conf.EnableLog && dt > 500*time.Millisecond {
+		if conf.EnableLog && dt > 500*time.Millisecond {
 			var stack string
 			if err != nil {
 				stack = fmt.Sprintf("%+v", err)
@@ -11,10 +11,11 @@ import (
 const (
 	serverNamespace = "rpc_server"
 	clientNamespace = "rpc_client"
+	configNamespace = "rpc_config"
 )
 
 var (
-	metricServerReqDur = metric.NewHistogramVec(&metric.HistogramVecOpts{
+	metricServerReqDur = newServerHistogramVec(&metric.HistogramVecOpts{
 		Namespace: serverNamespace,
 		Subsystem: "requests",
 		Name:      "duration_ms",
@@ -22,13 +23,74 @@ var (
 		Labels:    []string{"method", "caller"},
 		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000},
 	})
-	metricServerReqCodeTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+	metricServerReqCodeTotal = newServerCounterVec(&metric.CounterVecOpts{
 		Namespace: serverNamespace,
 		Subsystem: "requests",
 		Name:      "code_total",
 		Help:      "rpc server requests code count.",
 		Labels:    []string{"method", "caller", "code"},
 	})
+	metricServerReqClassTotal = newServerCounterVec(&metric.CounterVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "requests",
+		Name:      "class_total",
+		Help:      "rpc server requests code class count.",
+		Labels:    []string{"method", "caller", "class"},
+	})
+	metricServerReqBudget = newServerHistogramVec(&metric.HistogramVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "requests",
+		Name:      "budget_ms",
+		Help:      "rpc server requests remaining deadline budget sent by callers(ms).",
+		Labels:    []string{"method"},
+		Buckets:   []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+	})
+	metricServerLimiterInflight = newServerGaugeVec(&metric.GaugeVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "limiter",
+		Name:      "inflight",
+		Help:      "rpc server in-flight requests admitted by the concurrency limiter.",
+	})
+	metricServerLimiterQueue = newServerGaugeVec(&metric.GaugeVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "limiter",
+		Name:      "queue_depth",
+		Help:      "rpc server requests waiting in the concurrency limiter queue.",
+	})
+	metricServerLimiterLimit = newServerGaugeVec(&metric.GaugeVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "limiter",
+		Name:      "limit",
+		Help:      "rpc server concurrency limit.",
+	})
+	metricServerLimiterShedTotal = newServerCounterVec(&metric.CounterVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "limiter",
+		Name:      "shed_total",
+		Help:      "rpc server requests shed by the concurrency limiter.",
+		Labels:    []string{"method", "priority"},
+	})
+	metricServerIdempotentReplayTotal = newServerCounterVec(&metric.CounterVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "idempotent",
+		Name:      "replay_total",
+		Help:      "rpc server replayed idempotent requests count.",
+		Labels:    []string{"method"},
+	})
+	metricServerCoalescedTotal = newServerCounterVec(&metric.CounterVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "requests",
+		Name:      "coalesced_total",
+		Help:      "rpc server requests coalesced into another execution count.",
+		Labels:    []string{"method"},
+	})
+	metricServerFaultTotal = newServerCounterVec(&metric.CounterVecOpts{
+		Namespace: serverNamespace,
+		Subsystem: "fault",
+		Name:      "injected_total",
+		Help:      "rpc server injected faults count.",
+		Labels:    []string{"method", "type"},
+	})
 	metricClientReqDur = metric.NewHistogramVec(&metric.HistogramVecOpts{
 		Namespace: clientNamespace,
 		Subsystem: "requests",
@@ -37,6 +99,49 @@ var (
 		Labels:    []string{"method"},
 		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000},
 	})
+	metricClientFaultTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "fault",
+		Name:      "injected_total",
+		Help:      "rpc client injected faults count.",
+		Labels:    []string{"method", "type"},
+	})
+	metricClientMirrorTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "mirror",
+		Name:      "requests_total",
+		Help:      "rpc client mirrored requests count by comparison result.",
+		Labels:    []string{"method", "result"},
+	})
+	metricClientMirrorDur = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "mirror",
+		Name:      "duration_ms",
+		Help:      "rpc client mirrored requests duration(ms) of primary and shadow calls.",
+		Labels:    []string{"method", "side"},
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000},
+	})
+	metricClientColorFallbackTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "color",
+		Name:      "fallback_total",
+		Help:      "rpc client colored requests routed to the default lane count.",
+		Labels:    []string{"color"},
+	})
+	metricClientSplitTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "split",
+		Name:      "requests_total",
+		Help:      "rpc client requests count by split target.",
+		Labels:    []string{"method", "target"},
+	})
+	metricClientCacheTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "rpc client response cache requests count.",
+		Labels:    []string{"method", "result"},
+	})
 	metricClientReqCodeTotal = metric.NewCounterVec(&metric.CounterVecOpts{
 		Namespace: clientNamespace,
 		Subsystem: "requests",
@@ -44,6 +149,34 @@ var (
 		Help:      "rpc client requests code count.",
 		Labels:    []string{"method", "code"},
 	})
+	metricClientHedgeWinTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "hedge",
+		Name:      "win_total",
+		Help:      "rpc client hedged requests win count by attempt index, 0 is the original request.",
+		Labels:    []string{"method", "attempt"},
+	})
+	metricClientThrottleRejectTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "throttle",
+		Name:      "reject_total",
+		Help:      "rpc client requests rejected locally by adaptive throttling.",
+		Labels:    []string{"target", "method"},
+	})
+	metricClientThrottleRatio = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: clientNamespace,
+		Subsystem: "throttle",
+		Name:      "reject_ratio",
+		Help:      "rpc client adaptive throttling reject probability.",
+		Labels:    []string{"target"},
+	})
+	metricConfigRejectedTotal = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: configNamespace,
+		Subsystem: "updates",
+		Name:      "rejected_total",
+		Help:      "rpc config updates rejected count.",
+		Labels:    []string{"path"},
+	})
 )
 
 // 基于prometheus实现指标收集功能
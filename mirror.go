@@ -0,0 +1,160 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	_defaultMirrorTimeout     = time.Second // 影子请求默认的超时时间
+	_defaultMirrorConcurrency = 100         // 默认最多同时进行的影子请求数量
+)
+
+// MirrorConfig 流量镜像配置,将一定比例的线上请求异步复制到另一个目标,影子请求的响应会与线上响应比较后丢弃.
+type MirrorConfig struct {
+	Target      string         `json:"target"`      // 影子请求的目标地址
+	Percentage  float64        `json:"percentage"`  // 镜像的请求百分比,取值范围为(0,100]
+	Timeout     utils.Duration `json:"timeout"`     // 影子请求的超时时间,默认值为 1s
+	Concurrency int            `json:"concurrency"` // 最多同时进行的影子请求数量,超过时丢弃新的影子请求,默认值为 100
+}
+
+// shadow 流量镜像的状态
+type shadow struct {
+	mutex sync.RWMutex
+	conf  *MirrorConfig
+	conn  *sharedConn
+	slots chan struct{} // 限制同时进行的影子请求数量
+}
+
+// SetMirror 热更新流量镜像配置,目标地址发生变化时重新建立影子连接,conf为空时关闭流量镜像.
+// 影子连接使用与主连接相同的选项,主连接尚未建立时在建立后再连接.
+func (c *Client) SetMirror(conf *MirrorConfig) (err error) {
+	c.shadow.mutex.Lock()
+	defer c.shadow.mutex.Unlock()
+	concurrency := _defaultMirrorConcurrency
+	if conf != nil && conf.Concurrency > 0 {
+		concurrency = conf.Concurrency
+	}
+	if cap(c.shadow.slots) != concurrency {
+		c.shadow.slots = make(chan struct{}, concurrency)
+	}
+	if conf != nil && c.shadow.conf != nil && conf.Target == c.shadow.conf.Target && c.shadow.conn != nil {
+		c.shadow.conf = conf
+		return nil
+	}
+	if c.shadow.conn != nil {
+		c.shadow.conn.retire()
+	}
+	c.shadow.conf, c.shadow.conn = nil, nil
+	if conf == nil || conf.Target == "" {
+		return nil
+	}
+	conn, err := c.dialSide(conf.Target)
+	if err != nil {
+		return err
+	}
+	c.shadow.conf = conf
+	if conn != nil {
+		c.shadow.conn = &sharedConn{conn: conn}
+	}
+	return nil
+}
+
+// connectMirror 主连接建立后为已经配置的流量镜像建立连接
+func (c *Client) connectMirror() {
+	c.shadow.mutex.RLock()
+	conf, pending := c.shadow.conf, c.shadow.conf != nil && c.shadow.conn == nil
+	c.shadow.mutex.RUnlock()
+	if pending {
+		if err := c.SetMirror(conf); err != nil {
+			fmt.Printf("RPC流量镜像[%s]连接出错:%+v\n", conf.Target, err)
+		}
+	}
+}
+
+// mirror 返回流量镜像的客户端拦截器,线上请求完成后异步发起影子请求.
+// 影子请求携带 metacode.Mirror 元数据,处理镜像请求时不会再次镜像,以避免循环;
+// 同时进行的影子请求达到上限时丢弃新的影子请求.
+func (c *Client) mirror(caller []string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		reqMsg, ok := req.(proto.Message)
+		replyMsg, ok2 := reply.(proto.Message)
+		c.shadow.mutex.RLock()
+		conf, sc, slots := c.shadow.conf, c.shadow.conn, c.shadow.slots
+		if !ok || !ok2 || conf == nil || sc == nil || metacode.Bool(ctx, metacode.Mirror) || rand.Float64()*100 >= conf.Percentage {
+			c.shadow.mutex.RUnlock()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		conn := sc.acquire()
+		c.shadow.mutex.RUnlock()
+
+		// 调用方可能在返回后修改请求,因此提前复制
+		shadowReq := proto.Clone(reqMsg)
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		metricClientMirrorDur.Observe(time.Since(start).Milliseconds(), method, "primary")
+		select {
+		case slots <- struct{}{}:
+		default:
+			sc.release()
+			metricClientMirrorTotal.Inc(method, "dropped")
+			return err
+		}
+		shadowReply := proto.Clone(replyMsg)
+		shadowReply.Reset()
+		var primaryReply proto.Message
+		if err == nil {
+			primaryReply = proto.Clone(replyMsg)
+		}
+		go func() {
+			defer func() {
+				sc.release()
+				<-slots
+			}()
+			c.shadowCall(conn, conf, caller, method, shadowReq, shadowReply, primaryReply, err)
+		}()
+		return err
+	}
+}
+
+// shadowCall 发起影子请求并与线上请求的结果进行比较
+func (c *Client) shadowCall(conn *grpc.ClientConn, conf *MirrorConfig, caller []string, method string, req, reply, primaryReply proto.Message, primaryErr error) {
+	timeout := time.Duration(conf.Timeout)
+	if timeout <= 0 {
+		timeout = _defaultMirrorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.MD{metacode.Caller: caller, metacode.Mirror: []string{"true"}})
+
+	start := time.Now()
+	err := conn.Invoke(ctx, method, req, reply)
+	metricClientMirrorDur.Observe(time.Since(start).Milliseconds(), method, "shadow")
+
+	result := "match"
+	switch {
+	case err != nil && primaryErr != nil:
+		if ToMetaCode(status.Convert(err)).Code() != metacode.Cause(primaryErr).Code() {
+			result = "diff"
+		}
+	case err != nil:
+		result = "error"
+	case primaryErr != nil, !proto.Equal(reply, primaryReply):
+		result = "diff"
+	}
+	if result != "match" {
+		log.Debug().Str("method", method).Str("target", conf.Target).Str("result", result).Msg("rpc mirror response differs")
+	}
+	metricClientMirrorTotal.Inc(method, result)
+}
@@ -0,0 +1,128 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestServer 启动测试服务器并返回监听地址
+func startTestServer(invoker func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error)) (string, func()) {
+	srv := NewServer(&ServerConfig{Network: "tcp", Timeout: utils.Duration(time.Second)})
+	pb.RegisterGreeterServer(srv.Server(), &testServer{helloFn: invoker})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go func() { _ = srv.Serve(lis) }()
+	return lis.Addr().String(), func() { _ = srv.Shutdown(context.Background()) }
+}
+
+// counterValue 返回默认注册表中指定标签的计数器值
+func counterValue(name string, labels map[string]string) float64 {
+	mfs, _ := prometheus.DefaultGatherer.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue next
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestMirror(t *testing.T) {
+	const method = "/testproto.Greeter/SayHello"
+	var mirrored int32
+	primary, stopPrimary := startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	})
+	defer stopPrimary()
+	shadowAddr, stopShadow := startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		atomic.AddInt32(&mirrored, 1)
+		assert.True(t, metacode.Bool(ctx, metacode.Mirror))
+		assert.Equal(t, "10000", metacode.ToString(ctx, metacode.Caller))
+		if req.Name == "diff" {
+			return &pb.HelloReply{Success: true, Message: "v2"}, nil
+		}
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	})
+	defer stopShadow()
+
+	client := NewClient(&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	assert.Nil(t, client.SetMirror(&MirrorConfig{Target: shadowAddr, Percentage: 100}))
+	defer client.SetMirror(nil)
+	conn, err := client.Dial(context.Background(), primary, []string{"10000"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	cli := pb.NewGreeterClient(conn)
+
+	match := counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "match"})
+	diff := counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "diff"})
+	for _, name := range []string{"same", "diff"} {
+		reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: name})
+		assert.Nil(t, err)
+		// 影子请求的响应不会影响线上请求
+		assert.Equal(t, name, reply.Message)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&mirrored) == 2 }, time.Second, time.Millisecond*10)
+	assert.Eventually(t, func() bool {
+		return counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "match"}) == match+1 &&
+			counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "diff"}) == diff+1
+	}, time.Second, time.Millisecond*10)
+
+	// 关闭流量镜像后不再发起影子请求
+	assert.Nil(t, client.SetMirror(nil))
+	_, _ = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "same"})
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&mirrored))
+}
+
+func TestMirrorConcurrency(t *testing.T) {
+	const method = "/testproto.Greeter/SayHello"
+	primary, stopPrimary := startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	})
+	defer stopPrimary()
+	release := make(chan struct{})
+	shadowAddr, stopShadow := startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		<-release
+		return &pb.HelloReply{Success: true, Message: req.Name}, nil
+	})
+	defer stopShadow()
+
+	client := NewClient(&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	conn, err := client.Dial(context.Background(), primary, []string{"10000"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	// 主连接建立后配置的流量镜像立即连接
+	assert.Nil(t, client.SetMirror(&MirrorConfig{Target: shadowAddr, Percentage: 100, Concurrency: 1}))
+	defer client.SetMirror(nil)
+	assert.NotNil(t, client.shadow.conn)
+	cli := pb.NewGreeterClient(conn)
+
+	dropped := counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "dropped"})
+	for i := 0; i < 3; i++ {
+		_, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "slow"})
+		assert.Nil(t, err)
+	}
+	// 同时进行的影子请求达到上限后丢弃新的影子请求,线上请求不受影响
+	assert.Equal(t, dropped+2, counterValue("rpc_client_mirror_requests_total", map[string]string{"method": method, "result": "dropped"}))
+	close(release)
+	assert.Eventually(t, func() bool { return len(client.shadow.slots) == 0 }, time.Second, time.Millisecond*10)
+}
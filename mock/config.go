@@ -0,0 +1,16 @@
+// Package mock 提供用于单元测试的内存配置中心以及RpcEngine实现,不依赖真实的配置中心.
+package mock
+
+import (
+	rpc "github.com/aluka-7/grpc"
+)
+
+// Configuration 内存配置中心,与本地配置共用 rpc.LocalConfiguration 的实现,
+// 配置项的路径与配置中心相同,例如 /system/base/app/10000.
+// 配置变化时同步通知监听器,便于确定性地测试配置热更新.
+type Configuration = rpc.LocalConfiguration
+
+// NewConfiguration 使用配置项路径到配置内容的映射创建内存配置中心.
+func NewConfiguration(data map[string]string) *Configuration {
+	return rpc.NewMemoryConfiguration(data)
+}
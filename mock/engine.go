@@ -0,0 +1,118 @@
+package mock
+
+import (
+	"sync"
+
+	rpc "github.com/aluka-7/grpc"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// Engine 用于单元测试的RpcEngine实现,未指定连接时使用基于内存配置中心的引擎创建服务器以及客户端连接,
+// 同时记录每个systemId的Dial以及Release次数.
+type Engine struct {
+	rpc.RpcEngine
+	Config *Configuration
+
+	mutex    sync.Mutex
+	conns    map[string]*mockConn
+	dials    map[string]int
+	releases map[string]int
+}
+
+type mockConn struct {
+	conn *grpc.ClientConn
+	cfg  *rpc.RpcClientConfig
+}
+
+// NewEngine 使用配置项路径到配置内容的映射创建引擎.
+func NewEngine(systemId string, data map[string]string) *Engine {
+	conf := NewConfiguration(data)
+	return &Engine{
+		RpcEngine: rpc.Engine(systemId, conf),
+		Config:    conf,
+		conns:     make(map[string]*mockConn),
+		dials:     make(map[string]int),
+		releases:  make(map[string]int),
+	}
+}
+
+// SetConn 指定systemId对应的客户端连接,例如grpctest创建的连接,Dial时直接返回该连接以及cfg.
+func (e *Engine) SetConn(systemId string, conn *grpc.ClientConn, cfg *rpc.RpcClientConfig) *Engine {
+	if cfg == nil {
+		cfg = &rpc.RpcClientConfig{ClientConfig: &rpc.ClientConfig{}}
+	}
+	e.mutex.Lock()
+	e.conns[systemId] = &mockConn{conn: conn, cfg: cfg}
+	e.mutex.Unlock()
+	return e
+}
+
+func (e *Engine) OnReject(fn rpc.RejectFunc) rpc.RpcEngine {
+	e.RpcEngine.OnReject(fn)
+	return e
+}
+
+func (e *Engine) ClientConn(systemId string, handlers ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, *rpc.RpcClientConfig) {
+	conn, cfg, err := e.Dial(systemId, handlers...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return conn, cfg
+}
+
+func (e *Engine) Dial(systemId string, handlers ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, *rpc.RpcClientConfig, error) {
+	e.mutex.Lock()
+	mc, ok := e.conns[systemId]
+	if ok {
+		e.dials[systemId]++
+	}
+	e.mutex.Unlock()
+	if ok {
+		return mc.conn, mc.cfg, nil
+	}
+	conn, cfg, err := e.RpcEngine.Dial(systemId, handlers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.mutex.Lock()
+	e.dials[systemId]++
+	e.mutex.Unlock()
+	return conn, cfg, nil
+}
+
+func (e *Engine) Release(systemId string) error {
+	e.mutex.Lock()
+	_, ok := e.conns[systemId]
+	if ok {
+		if e.releases[systemId] >= e.dials[systemId] {
+			e.mutex.Unlock()
+			return errors.Errorf("RPC客户端连接[%s]不存在", systemId)
+		}
+		e.releases[systemId]++
+		e.mutex.Unlock()
+		return nil
+	}
+	e.mutex.Unlock()
+	if err := e.RpcEngine.Release(systemId); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	e.releases[systemId]++
+	e.mutex.Unlock()
+	return nil
+}
+
+// Dials 返回systemId成功的Dial以及ClientConn调用次数.
+func (e *Engine) Dials(systemId string) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.dials[systemId]
+}
+
+// Releases 返回systemId成功的Release调用次数.
+func (e *Engine) Releases(systemId string) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.releases[systemId]
+}
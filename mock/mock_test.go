@@ -0,0 +1,96 @@
+package mock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/grpc/grpctest"
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type greeter struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (g *greeter) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	return &pb.HelloReply{Message: "Hello " + req.Name, Success: true}, nil
+}
+
+func TestConfiguration(t *testing.T) {
+	conf := NewConfiguration(map[string]string{"/system/base/app/1000": `{"timeout":"1s"}`})
+	var pushed []map[string]string
+	conf.Get("base", "app", "", []string{"1000"}, listenerFunc(func(data map[string]string) {
+		pushed = append(pushed, data)
+	}))
+	assert.Equal(t, 1, conf.Listeners("/system/base/app/1000"))
+	conf.Set("/system/base/app/1000", `{"timeout":"2s"}`)
+	conf.Push("/system/base/app/1000")
+	conf.Delete("/system/base/app/1000")
+	conf.Set("/system/base/app/2000", `{}`)
+	assert.Equal(t, []map[string]string{
+		{"/system/base/app/1000": `{"timeout":"1s"}`},
+		{"/system/base/app/1000": `{"timeout":"2s"}`},
+		{"/system/base/app/1000": `{"timeout":"2s"}`},
+		{},
+	}, pushed)
+	_, err := conf.String("base", "app", "", "1000")
+	assert.NotNil(t, err)
+}
+
+type listenerFunc func(data map[string]string)
+
+func (f listenerFunc) Changed(data map[string]string) { f(data) }
+
+func TestEngineHotReload(t *testing.T) {
+	engine := NewEngine("10000", map[string]string{
+		"/system/base/app/1000": `{"timeout":"1s","tag":[{"key":"version","value":"1.0.0"}]}`,
+		"/system/base/rpc/1000": `{"target":"127.0.0.1:1","timeout":"1s","nonBlock":true}`,
+	})
+	var rejected []string
+	engine.OnReject(func(path string, err error) {
+		rejected = append(rejected, path)
+	})
+	s, _, err := engine.NewServer(false, "base", "app", "1000")
+	assert.Nil(t, err)
+	engine.Config.Set("/system/base/app/1000", `{"timeout":"1s","tag":[{"key":"version","value":"1.1.0"}]}`)
+	assert.Equal(t, map[string]string{"version": "1.1.0"}, s.Metadata())
+	engine.Config.Set("/system/base/app/1000", `{"timeout":"-1s","tag":[{"key":"version","value":"1.2.0"}]}`)
+	assert.Equal(t, map[string]string{"version": "1.1.0"}, s.Metadata())
+	assert.Equal(t, []string{"/system/base/app/1000"}, rejected)
+
+	_, _, err = engine.Dial("1000")
+	assert.Nil(t, err)
+	engine.Config.Set("/system/base/rpc/1000", `{"target":"127.0.0.1:1","timeout":"2s","nonBlock":true}`)
+	_, cfg, err := engine.Dial("1000")
+	assert.Nil(t, err)
+	assert.Equal(t, utils.Duration(time.Second*2), cfg.Timeout)
+	assert.Equal(t, 2, engine.Dials("1000"))
+	// 失败的Dial不计数
+	_, _, err = engine.Dial("missing")
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, engine.Dials("missing"))
+	assert.Nil(t, engine.Release("1000"))
+	assert.Nil(t, engine.Release("1000"))
+	assert.NotNil(t, engine.Release("1000"))
+	assert.Equal(t, 2, engine.Releases("1000"))
+	assert.Nil(t, engine.Shutdown(context.Background()))
+}
+
+func TestEngineSetConn(t *testing.T) {
+	h := grpctest.New(t, func(s *grpc.Server) {
+		pb.RegisterGreeterServer(s, &greeter{})
+	})
+	engine := NewEngine("10000", nil).SetConn("1000", h.Conn, nil)
+	conn, cfg := engine.ClientConn("1000")
+	assert.NotNil(t, cfg.ClientConfig)
+	reply, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "aluka"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello aluka", reply.Message)
+	assert.Nil(t, engine.Release("1000"))
+	assert.NotNil(t, engine.Release("1000"))
+	assert.Panics(t, func() { engine.ClientConn("2000") })
+}
@@ -0,0 +1,322 @@
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Recording 录制的一次一元调用,以JSON行的格式保存,请求与响应为protobuf序列化后的数据.
+type Recording struct {
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Request  []byte            `json:"request"`
+	Response []byte            `json:"response,omitempty"`
+	Code     int               `json:"code"`              // metacode错误码,成功时为0
+	Message  string            `json:"message,omitempty"` // 错误信息
+	Status   []byte            `json:"status,omitempty"`  // 失败时序列化后的gRPC状态,包含错误详情
+}
+
+// Recorder 将客户端的一元调用录制到JSON行文件中,用于离线回放.
+type Recorder struct {
+	mutex  sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewRecorder 将录制的调用写入w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// NewFileRecorder 将录制的调用追加到文件中.
+func NewFileRecorder(file string) (*Recorder, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// Interceptor 返回录制调用的客户端拦截器,通过 Client.Use 添加.
+func (r *Recorder) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if e := r.record(ctx, method, req, reply, err); e != nil {
+			fmt.Printf("rpc: 录制[%s]调用出错:%+v\n", method, e)
+		}
+		return err
+	}
+}
+
+func (r *Recorder) record(ctx context.Context, method string, req, reply interface{}, err error) error {
+	rec := &Recording{Time: time.Now(), Method: method, Metadata: recordMetadata(ctx)}
+	var e error
+	if rec.Request, e = marshalDeterministic(req); e != nil {
+		return e
+	}
+	if err != nil {
+		gst := FromError(err)
+		ec := ToMetaCode(gst)
+		rec.Code, rec.Message = ec.Code(), ec.Message()
+		if rec.Status, e = proto.Marshal(gst.Proto()); e != nil {
+			return errors.WithStack(e)
+		}
+	} else if rec.Response, e = marshalDeterministic(reply); e != nil {
+		return e
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return errors.WithStack(r.enc.Encode(rec))
+}
+
+// Close 关闭录制文件.
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// recordMetadata 获取调用需要传递的元数据,只录制metacode传递的键以及Color,
+// 不录制gRPC的出站元数据,避免authorization等凭证以明文写入录制文件
+func recordMetadata(ctx context.Context) map[string]string {
+	md := make(map[string]string)
+	metacode.Range(ctx, func(key string, value interface{}) {
+		md[key] = fmt.Sprint(value)
+	}, func(key string) bool {
+		return metacode.IsOutgoingKey(key) || key == Color
+	})
+	if len(md) == 0 {
+		return nil
+	}
+	return md
+}
+
+// marshalDeterministic 确定性地序列化消息,便于按照请求匹配录制的调用
+func marshalDeterministic(msg interface{}) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("rpc: %T不是protobuf消息", msg)
+	}
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Replayer 按照方法以及请求回放录制的调用,相同的请求录制了多次时按照录制的顺序依次回放,之后重复回放最后一次.
+type Replayer struct {
+	mutex      sync.Mutex
+	recordings map[string][]*Recording
+	next       map[string]int
+}
+
+// NewReplayer 从JSON行格式的数据中加载录制的调用.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{recordings: make(map[string][]*Recording), next: make(map[string]int)}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		b := bytes.TrimSpace(s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		rec := new(Recording)
+		if err := json.Unmarshal(b, rec); err != nil {
+			return nil, errors.Wrapf(err, "解析第%d行录制的调用出错", line)
+		}
+		key := replayKey(rec.Method, normalize(rec.Method, rec.Request))
+		p.recordings[key] = append(p.recordings[key], rec)
+	}
+	return p, errors.WithStack(s.Err())
+}
+
+// NewFileReplayer 从文件中加载录制的调用.
+func NewFileReplayer(file string) (*Replayer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	return NewReplayer(f)
+}
+
+func replayKey(method string, req []byte) string {
+	return method + "|" + string(req)
+}
+
+// lookup 查找请求对应的录制
+func (p *Replayer) lookup(method string, req []byte) (*Recording, bool) {
+	key := replayKey(method, req)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	recs := p.recordings[key]
+	if len(recs) == 0 {
+		return nil, false
+	}
+	i := p.next[key]
+	if i < len(recs)-1 {
+		p.next[key] = i + 1
+	}
+	return recs[i], true
+}
+
+// status 返回录制的调用失败时的gRPC状态,成功时返回nil
+func (rec *Recording) status() (*status.Status, error) {
+	if len(rec.Status) == 0 {
+		return nil, nil
+	}
+	st := new(spb.Status)
+	if err := proto.Unmarshal(rec.Status, st); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return status.FromProto(st), nil
+}
+
+// notRecorded 返回请求没有录制时的错误
+func notRecorded(method string) error {
+	return metacode.Error(metacode.NothingFound, fmt.Sprintf("rpc: 没有录制[%s]的调用", method))
+}
+
+// Interceptor 返回回放录制调用的客户端拦截器,通过 Client.Use 添加,不会调用真实的下游服务,
+// 没有录制的请求返回metacode.NothingFound.
+func (p *Replayer) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		b, err := marshalDeterministic(req)
+		if err != nil {
+			return err
+		}
+		rec, ok := p.lookup(method, b)
+		if !ok {
+			return notRecorded(method)
+		}
+		gst, err := rec.status()
+		if err != nil {
+			return err
+		}
+		if gst != nil {
+			return ToMetaCode(gst)
+		}
+		msg, ok := reply.(proto.Message)
+		if !ok {
+			return errors.Errorf("rpc: %T不是protobuf消息", reply)
+		}
+		return errors.WithStack(proto.Unmarshal(rec.Response, msg))
+	}
+}
+
+// ServerOptions 返回回放服务器的选项,所有未注册的服务都由录制的调用响应,
+// 可以作为下游服务的替身使用,例如 NewServer(conf, replayer.ServerOptions()...).
+// 只添加未注册服务的处理程序,不会修改服务器的编解码器.
+func (p *Replayer) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.UnknownServiceHandler(p.handler)}
+}
+
+// handler 使用录制的调用响应未注册服务的请求,请求按照方法的请求类型解码后重新确定性地序列化再查找录制,
+// 与录制时的序列化方式一致;方法的描述未注册时以未知字段的形式收发序列化后的数据.
+func (p *Replayer) handler(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	in, out := messageTypes(method)
+	req := proto.MessageV1(newMessage(in))
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	b, err := marshalDeterministic(req)
+	if err != nil {
+		return FromError(err).Err()
+	}
+	rec, ok := p.lookup(method, b)
+	if !ok {
+		return FromError(notRecorded(method)).Err()
+	}
+	gst, err := rec.status()
+	if err != nil {
+		return FromError(err).Err()
+	}
+	if gst != nil {
+		return gst.Err()
+	}
+	reply := proto.MessageV1(newMessage(out))
+	if err = proto.Unmarshal(rec.Response, reply); err != nil {
+		return FromError(errors.WithStack(err)).Err()
+	}
+	return stream.SendMsg(reply)
+}
+
+// normalize 按照方法的请求类型重新确定性地序列化请求,方法的描述未注册或者解码失败时返回原始数据
+func normalize(method string, req []byte) []byte {
+	in, _ := messageTypes(method)
+	if in == nil {
+		return req
+	}
+	m := proto.MessageV1(in.New().Interface())
+	if err := proto.Unmarshal(req, m); err != nil {
+		return req
+	}
+	b, err := marshalDeterministic(m)
+	if err != nil {
+		return req
+	}
+	return b
+}
+
+// messageTypes 从已注册的protobuf描述中查找方法的请求与响应类型,未注册时返回nil
+func messageTypes(method string) (in, out protoreflect.MessageType) {
+	i := strings.LastIndex(method, "/")
+	if i <= 0 {
+		return nil, nil
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(method[:i], "/")))
+	if err != nil {
+		return nil, nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, nil
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method[i+1:]))
+	if md == nil {
+		return nil, nil
+	}
+	return messageType(md.Input()), messageType(md.Output())
+}
+
+// messageType 优先使用生成的消息类型,未注册时使用动态消息
+func messageType(md protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		return mt
+	}
+	return dynamicpb.NewMessageType(md)
+}
+
+// newMessage 创建消息,类型未知时使用以未知字段保存全部数据的空消息
+func newMessage(mt protoreflect.MessageType) protoreflect.ProtoMessage {
+	if mt == nil {
+		return new(emptypb.Empty)
+	}
+	return mt.New().Interface()
+}
@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var calls int
+	addr, stop := startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+		calls++
+		if req.Name == "conflict" {
+			return nil, metacode.Conflict
+		}
+		return &pb.HelloReply{Message: "Hello " + req.Name, Success: true}, nil
+	})
+	defer stop()
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	conf := &ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)}
+	conn, err := NewClient(conf).Use(rec.Interceptor()).Dial(context.Background(), addr, []string{"10000"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	cli := pb.NewGreeterClient(conn)
+	ctx := metacode.NewContext(context.Background(), metacode.Metadata{metacode.Criticality: "critical"})
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret-token")
+	_, err = cli.SayHello(ctx, &pb.HelloRequest{Name: "aluka", Age: 18})
+	assert.Nil(t, err)
+	_, err = cli.SayHello(ctx, &pb.HelloRequest{Name: "conflict"})
+	assert.True(t, metacode.EqualError(metacode.Conflict, err))
+	assert.Equal(t, 2, calls)
+	assert.Contains(t, buf.String(), `"metadata":{"criticality":"critical"}`)
+	// 凭证等gRPC出站元数据不写入录制文件
+	assert.NotContains(t, buf.String(), "authorization")
+	assert.NotContains(t, buf.String(), "secret-token")
+
+	data := buf.Bytes()
+	check := func(t *testing.T, cli pb.GreeterClient) {
+		reply, err := cli.SayHello(context.Background(), &pb.HelloRequest{Name: "aluka", Age: 18})
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello aluka", reply.Message)
+		_, err = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "conflict"})
+		assert.True(t, metacode.EqualError(metacode.Conflict, err))
+		_, err = cli.SayHello(context.Background(), &pb.HelloRequest{Name: "unknown"})
+		assert.True(t, metacode.EqualError(metacode.NothingFound, err))
+	}
+
+	t.Run("client", func(t *testing.T) {
+		replayer, err := NewReplayer(bytes.NewReader(data))
+		assert.Nil(t, err)
+		conn, err := NewClient(&ClientConfig{Timeout: utils.Duration(time.Second), NonBlock: true}).
+			Use(replayer.Interceptor()).Dial(context.Background(), "127.0.0.1:1", []string{"10000"})
+		assert.Nil(t, err)
+		defer conn.Close()
+		check(t, pb.NewGreeterClient(conn))
+	})
+	t.Run("server", func(t *testing.T) {
+		replayer, err := NewReplayer(bytes.NewReader(data))
+		assert.Nil(t, err)
+		srv := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)}, replayer.ServerOptions()...)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		go func() { _ = srv.Serve(lis) }()
+		defer srv.Shutdown(context.Background())
+		conn, err := NewConn(lis.Addr().String(), conf, []string{"10000"})
+		assert.Nil(t, err)
+		defer conn.Close()
+		check(t, pb.NewGreeterClient(conn))
+
+		// 字段顺序与录制时不同的请求在重新确定性地序列化后仍然匹配
+		var b []byte
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, 18)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, "aluka")
+		req := new(emptypb.Empty)
+		req.ProtoReflect().SetUnknown(b)
+		reply := new(pb.HelloReply)
+		assert.Nil(t, conn.Invoke(context.Background(), "/testproto.Greeter/SayHello", req, reply))
+		assert.Equal(t, "Hello aluka", reply.Message)
+	})
+	assert.Equal(t, 2, calls)
+}
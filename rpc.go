@@ -4,24 +4,48 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aluka-7/configuration"
 	"github.com/aluka-7/trace"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 )
 
 type RpcServerConfig struct {
 	*ServerConfig
-	Tag []trace.Tag `json:"tag"`
+	Tag []trace.Tag `json:"tag"` // 服务器标签,添加到链路追踪、服务端指标的常量标签以及服务注册的元数据中
 }
 type RpcClientConfig struct {
 	*ClientConfig
-	Target string `json:"target"`
+	Target  string          `json:"target"`
+	Targets []*TargetConfig `json:"targets"` // 流量拆分配置,用于在稳定版本与灰度版本之间拆分流量,为空时全部请求发往Target
+	Mirror  *MirrorConfig   `json:"mirror"`  // 流量镜像配置,为空时不镜像
 }
 
+// RejectFunc 配置中心推送的配置更新被拒绝时的回调,path为配置的路径,err为拒绝的原因.
+type RejectFunc func(path string, err error)
+
 type RpcEngine interface {
+	// Server 创建rpc服务器,出错时panic.
 	Server(monitor bool, app, group, path string, handlers ...grpc.UnaryServerInterceptor) (*Server, *RpcServerConfig)
+	// ClientConn 返回rpc客户端连接,出错时panic.
 	ClientConn(systemId string, handlers ...grpc.UnaryClientInterceptor) (conn *grpc.ClientConn, cc *RpcClientConfig)
+	// NewServer 创建rpc服务器,配置不存在或者非法时返回错误.
+	NewServer(monitor bool, app, group, path string, handlers ...grpc.UnaryServerInterceptor) (*Server, *RpcServerConfig, error)
+	// Dial 返回rpc客户端连接,同一systemId共享同一个连接并使用首次Dial时的拦截器,配置不存在、非法或者连接失败时返回错误.
+	Dial(systemId string, handlers ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, *RpcClientConfig, error)
+	// Release 释放Dial或者ClientConn返回的连接,所有引用都释放后关闭连接,Shutdown之后调用不做任何处理.
+	Release(systemId string) error
+	// OnReject 注册配置更新被拒绝时的回调,被拒绝时继续使用上一次有效的配置.
+	OnReject(fn RejectFunc) RpcEngine
+	// Start 启动引擎创建的所有服务器.
+	Start() error
+	// Shutdown 优雅关闭引擎创建的所有服务器以及客户端连接.
+	Shutdown(ctx context.Context) error
+	// Run 启动所有服务器并阻塞到收到退出信号,之后在timeout时间内关闭引擎.
+	Run(timeout time.Duration) error
 }
 
 func Engine(systemId string, cfg configuration.Configuration) RpcEngine {
@@ -32,95 +56,311 @@ func Engine(systemId string, cfg configuration.Configuration) RpcEngine {
 type rpcEngine struct {
 	systemId string
 	cfg      configuration.Configuration
+
+	mutex    sync.RWMutex
+	rejects  []RejectFunc
+	servers  []*engineServer
+	conns    map[string]*engineConn // 按照systemId共享的客户端连接
+	shutdown bool                   // 是否已经调用过Shutdown
+}
+
+func (r *rpcEngine) OnReject(fn RejectFunc) RpcEngine {
+	r.mutex.Lock()
+	r.rejects = append(r.rejects, fn)
+	r.mutex.Unlock()
+	return r
+}
+
+// reject 记录被拒绝的配置更新并触发回调
+func (r *rpcEngine) reject(path string, err error) {
+	fmt.Printf("拒绝[%s]RPC配置更新,继续使用上一次有效的配置:%+v\n", path, err)
+	metricConfigRejectedTotal.Inc(path)
+	r.mutex.RLock()
+	rejects := r.rejects
+	r.mutex.RUnlock()
+	for _, fn := range rejects {
+		fn(path, err)
+	}
 }
 
 func (r *rpcEngine) Server(monitor bool, app, group, path string, handlers ...grpc.UnaryServerInterceptor) (*Server, *RpcServerConfig) {
-	scc := &serverConfigChanged{path: fmt.Sprintf("/system/%s/%s/%s", app, group, path), handlers: handlers}
+	s, cfg, err := r.NewServer(monitor, app, group, path, handlers...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return s, cfg
+}
+
+func (r *rpcEngine) NewServer(monitor bool, app, group, path string, handlers ...grpc.UnaryServerInterceptor) (*Server, *RpcServerConfig, error) {
+	scc := &serverConfigChanged{engine: r, path: fmt.Sprintf("/system/%s/%s/%s", app, group, path), handlers: handlers}
 	r.cfg.Get(app, group, "", []string{path}, scc)
+	s, cfg, err := scc.Server()
+	if err != nil {
+		return nil, nil, err
+	}
 	if monitor {
 		go metrics()
 	}
-	return scc.Server()
+	r.addServer(s)
+	return s, cfg, nil
 }
 
 type serverConfigChanged struct {
+	engine   *rpcEngine
 	path     string
 	handlers []grpc.UnaryServerInterceptor
+	mutex    sync.RWMutex
 	server   *Server
 	cfg      *RpcServerConfig
+	err      error // 首次加载配置时的错误
+}
+
+// parseServerConfig 解析并校验服务器配置
+func parseServerConfig(path string, data map[string]string) (*RpcServerConfig, error) {
+	v, ok := data[path]
+	if !ok {
+		return nil, errors.Errorf("配置中心不存在[%s]RPC服务器配置", path)
+	}
+	cfg := new(RpcServerConfig)
+	if err := json.Unmarshal([]byte(v), cfg); err != nil {
+		return nil, errors.Wrapf(err, "从配置中心读取[%s]RPC服务器配置出错", path)
+	}
+	if cfg.ServerConfig == nil {
+		return nil, errors.Errorf("[%s]RPC服务器配置为空", path)
+	}
+	cfg.ServerConfig = cfg.ServerConfig.Defaults()
+	if err := cfg.ServerConfig.Validate(); err != nil {
+		return nil, errors.WithMessagef(err, "[%s]RPC服务器配置不合法", path)
+	}
+	return cfg, nil
 }
 
 func (scc *serverConfigChanged) Changed(data map[string]string) {
-	if v, ok := data[scc.path]; ok {
-		scc.cfg = new(RpcServerConfig)
-		if err := json.Unmarshal([]byte(v), scc.cfg); err == nil {
-			if scc.server == nil {
-				scc.server = NewServer(scc.cfg.ServerConfig)
-				if len(scc.handlers) > 0 {
-					scc.server.Use(scc.handlers...)
-				}
-			} else {
-				fmt.Printf("更新[%s]RPC服务器配置:%s\n", scc.path, v)
-				_ = scc.server.SetConfig(scc.cfg.ServerConfig)
-			}
-		} else {
-			panic(fmt.Sprintf("从配置中心读取[%s]RPC服务器配置出错:%+v", scc.path, err))
+	cfg, err := parseServerConfig(scc.path, data)
+	scc.mutex.Lock()
+	defer scc.mutex.Unlock()
+	if scc.server == nil {
+		if err != nil {
+			scc.err = err
+			return
 		}
-
-	} else {
-		panic(fmt.Sprintf("配置中心不存在[%s]RPC服务器配置", scc.path))
+		server := NewServer(cfg.ServerConfig)
+		if err = applyMetricTags(server, cfg.Tag); err != nil {
+			scc.err = errors.WithMessagef(err, "[%s]RPC服务器配置不合法", scc.path)
+			return
+		}
+		scc.server, scc.cfg, scc.err = server, cfg, nil
+		scc.server.SetTags(cfg.Tag...)
+		if len(scc.handlers) > 0 {
+			scc.server.Use(scc.handlers...)
+		}
+		return
 	}
+	if err == nil {
+		err = applyMetricTags(scc.server, cfg.Tag)
+	}
+	if err == nil {
+		if err = scc.server.SetConfig(cfg.ServerConfig); err != nil {
+			_ = applyMetricTags(scc.server, scc.cfg.Tag)
+		}
+	}
+	if err != nil {
+		scc.engine.reject(scc.path, err)
+		return
+	}
+	fmt.Printf("更新[%s]RPC服务器配置:%s\n", scc.path, data[scc.path])
+	scc.server.SetTags(cfg.Tag...)
+	scc.cfg = cfg
 }
-func (scc *serverConfigChanged) Server() (*Server, *RpcServerConfig) {
-	return scc.server, scc.cfg
+
+// Server 返回服务器以及当前有效的配置,首次加载配置失败时返回错误
+func (scc *serverConfigChanged) Server() (*Server, *RpcServerConfig, error) {
+	scc.mutex.RLock()
+	defer scc.mutex.RUnlock()
+	if scc.server == nil {
+		if scc.err != nil {
+			return nil, nil, scc.err
+		}
+		return nil, nil, errors.Errorf("配置中心不存在[%s]RPC服务器配置", scc.path)
+	}
+	return scc.server, scc.cfg, nil
 }
+
 func (r *rpcEngine) ClientConn(systemId string, handlers ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, *RpcClientConfig) {
-	ccc := &clientConfigChanged{path: fmt.Sprintf("/system/base/rpc/%s", systemId), handlers: handlers}
+	conn, cfg, err := r.Dial(systemId, handlers...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return conn, cfg
+}
+
+// Dial 返回systemId对应的客户端连接,同一systemId只注册一次配置监听并共享同一个连接,使用完毕后需要调用Release释放.
+// 共享的连接只按照systemId区分,始终使用首次Dial时的handlers,之后Dial传入的handlers不会生效.
+func (r *rpcEngine) Dial(systemId string, handlers ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, *RpcClientConfig, error) {
+	r.mutex.Lock()
+	if ec, ok := r.conns[systemId]; ok {
+		ec.refs++
+		r.mutex.Unlock()
+		<-ec.ready
+		if ec.err != nil {
+			return nil, nil, ec.err
+		}
+		_, cfg, _ := ec.ccc.Client()
+		return ec.conn, cfg, nil
+	}
+	ec := &engineConn{refs: 1, ready: make(chan struct{})}
+	if r.conns == nil {
+		r.conns = make(map[string]*engineConn)
+	}
+	r.conns[systemId] = ec
+	r.mutex.Unlock()
+
+	var cfg *RpcClientConfig
+	ec.ccc, ec.client, ec.conn, cfg, ec.err = r.dial(systemId, handlers...)
+	if ec.err != nil {
+		r.mutex.Lock()
+		if r.conns[systemId] == ec {
+			delete(r.conns, systemId)
+		}
+		r.mutex.Unlock()
+	}
+	close(ec.ready)
+	return ec.conn, cfg, ec.err
+}
+
+// Release 释放一次Dial返回的连接,引用计数为零时关闭连接并停止应用配置更新.
+// Shutdown已经关闭了所有连接,之后的Release不做任何处理.
+func (r *rpcEngine) Release(systemId string) error {
+	r.mutex.Lock()
+	ec, ok := r.conns[systemId]
+	if !ok {
+		shutdown := r.shutdown
+		r.mutex.Unlock()
+		if shutdown {
+			return nil
+		}
+		return errors.Errorf("RPC客户端连接[%s]不存在", systemId)
+	}
+	if ec.refs--; ec.refs > 0 {
+		r.mutex.Unlock()
+		return nil
+	}
+	delete(r.conns, systemId)
+	r.mutex.Unlock()
+	// 连接可能仍在创建中,创建完成后再关闭
+	<-ec.ready
+	if ec.err != nil {
+		return nil
+	}
+	return ec.close()
+}
+
+func (r *rpcEngine) dial(systemId string, handlers ...grpc.UnaryClientInterceptor) (*clientConfigChanged, *Client, *grpc.ClientConn, *RpcClientConfig, error) {
+	ccc := &clientConfigChanged{engine: r, path: fmt.Sprintf("/system/base/rpc/%s", systemId), handlers: handlers}
 	r.cfg.Get("base", "rpc", "", []string{systemId}, ccc)
-	client, cfg := ccc.Client()
+	client, cfg, err := ccc.Client()
+	if err != nil {
+		ccc.stop()
+		return nil, nil, nil, nil, err
+	}
+	if err = client.SetMirror(cfg.Mirror); err != nil {
+		fmt.Printf("RPC流量镜像[%s]连接出错:%+v\n", cfg.Mirror.Target, err)
+	}
+	if err = client.SetTargets(cfg.Targets); err != nil {
+		fmt.Printf("RPC流量拆分连接出错:%+v\n", err)
+	}
 	conn, err := client.Dial(context.Background(), cfg.Target, []string{r.systemId})
 	if err != nil {
-		panic(fmt.Sprintf("RPC连接远程服务出错:%+v\n", err))
+		ccc.stop()
+		_ = client.SetMirror(nil)
+		_ = client.SetTargets(nil)
+		return nil, nil, nil, nil, errors.WithMessage(err, "RPC连接远程服务出错")
 	}
-	return conn, cfg
+	return ccc, client, conn, cfg, nil
 }
 
 type clientConfigChanged struct {
+	engine   *rpcEngine
 	path     string
 	handlers []grpc.UnaryClientInterceptor
+	mutex    sync.RWMutex
 	client   *Client
 	cfg      *RpcClientConfig
+	err      error // 首次加载配置时的错误
+	stopped  bool  // 连接已经释放,不再应用配置更新
+}
+
+// stop 停止应用配置更新,配置中心不支持取消监听
+func (ccc *clientConfigChanged) stop() {
+	ccc.mutex.Lock()
+	ccc.stopped = true
+	ccc.mutex.Unlock()
+}
+
+// parseClientConfig 解析并校验客户端配置
+func parseClientConfig(path string, data map[string]string) (*RpcClientConfig, error) {
+	v, ok := data[path]
+	if !ok {
+		return nil, errors.Errorf("配置中心不存在[%s]RPC客户端配置", path)
+	}
+	cfg := new(RpcClientConfig)
+	if err := json.Unmarshal([]byte(v), cfg); err != nil {
+		return nil, errors.Wrapf(err, "从配置中心读取[%s]RPC客户端配置出错", path)
+	}
+	if cfg.ClientConfig == nil {
+		return nil, errors.Errorf("[%s]RPC客户端配置为空", path)
+	}
+	cfg.ClientConfig = cfg.ClientConfig.Defaults()
+	if err := cfg.ClientConfig.Validate(); err != nil {
+		return nil, errors.WithMessagef(err, "[%s]RPC客户端配置不合法", path)
+	}
+	return cfg, nil
 }
 
 func (ccc *clientConfigChanged) Changed(data map[string]string) {
-	if v, ok := data[ccc.path]; ok {
-		ccc.cfg = new(RpcClientConfig)
-		if err := json.Unmarshal([]byte(v), ccc.cfg); err == nil {
-			if ccc.cfg.Timeout == 0 || ccc.cfg.KeepAliveInterval == 0 || ccc.cfg.KeepAliveTimeout == 0 {
-				if ccc.client == nil {
-					panic("Timeout,KeepAliveInterval以及KeepAliveTimeout,必须大于零")
-				} else {
-					fmt.Println("Timeout,KeepAliveInterval以及KeepAliveTimeout,必须大于零,此设置没有生效")
-					return
-				}
-			}
-			if ccc.client == nil {
-				ccc.client = NewClient(ccc.cfg.ClientConfig)
-				if len(ccc.handlers) > 0 {
-					ccc.client.Use(ccc.handlers...)
-				}
-			} else {
-				fmt.Printf("更新[%s]RPC客户端配置为:%s\n", ccc.path, v)
-				_ = ccc.client.SetConfig(ccc.cfg.ClientConfig)
-			}
-		} else {
-			panic(fmt.Sprintf("从配置中心读取[%s]RPC客户端配置出错:%+v", ccc.path, err))
+	cfg, err := parseClientConfig(ccc.path, data)
+	ccc.mutex.Lock()
+	defer ccc.mutex.Unlock()
+	if ccc.stopped {
+		return
+	}
+	if ccc.client == nil {
+		if err != nil {
+			ccc.err = err
+			return
 		}
-
-	} else {
-		panic(fmt.Sprintf("配置中心不存在[%s]RPC客户端配置", ccc.path))
+		ccc.client, ccc.cfg, ccc.err = NewClient(cfg.ClientConfig), cfg, nil
+		if len(ccc.handlers) > 0 {
+			ccc.client.Use(ccc.handlers...)
+		}
+		return
+	}
+	if err == nil {
+		err = ccc.client.SetConfig(cfg.ClientConfig)
+	}
+	if err != nil {
+		ccc.engine.reject(ccc.path, err)
+		return
+	}
+	fmt.Printf("更新[%s]RPC客户端配置为:%s\n", ccc.path, data[ccc.path])
+	ccc.cfg = cfg
+	if err = ccc.client.SetMirror(cfg.Mirror); err != nil {
+		fmt.Printf("RPC流量镜像[%s]连接出错:%+v\n", cfg.Mirror.Target, err)
+	}
+	if err = ccc.client.SetTargets(cfg.Targets); err != nil {
+		fmt.Printf("RPC流量拆分连接出错:%+v\n", err)
 	}
 }
-func (ccc *clientConfigChanged) Client() (*Client, *RpcClientConfig) {
-	return ccc.client, ccc.cfg
+
+// Client 返回客户端以及当前有效的配置,首次加载配置失败时返回错误
+func (ccc *clientConfigChanged) Client() (*Client, *RpcClientConfig, error) {
+	ccc.mutex.RLock()
+	defer ccc.mutex.RUnlock()
+	if ccc.client == nil {
+		if ccc.err != nil {
+			return nil, nil, ccc.err
+		}
+		return nil, nil, errors.Errorf("配置中心不存在[%s]RPC客户端配置", ccc.path)
+	}
+	return ccc.client, ccc.cfg, nil
 }
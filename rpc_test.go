@@ -0,0 +1,196 @@
+package grpc_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	rpc "github.com/aluka-7/grpc"
+	"github.com/aluka-7/grpc/mock"
+	"github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// slowServer 延迟响应的测试服务
+type slowServer struct {
+	helloServer
+	delay time.Duration
+}
+
+func (s *slowServer) SayHello(ctx context.Context, in *testproto.HelloRequest) (*testproto.HelloReply, error) {
+	time.Sleep(s.delay)
+	return s.helloServer.SayHello(ctx, in)
+}
+
+func TestEngineErrors(t *testing.T) {
+	conf := mock.NewConfiguration(map[string]string{
+		"/system/base/app/bad":  "{",
+		"/system/base/rpc/bad":  `{"dial":"-1s"}`,
+		"/system/base/app/1000": `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"version","value":"1"}]}`,
+	})
+	engine := rpc.Engine("10000", conf)
+	defer engine.Shutdown(context.Background())
+
+	_, _, err := engine.NewServer(false, "base", "app", "missing")
+	assert.NotNil(t, err)
+	_, _, err = engine.NewServer(false, "base", "app", "bad")
+	assert.NotNil(t, err)
+	_, _, err = engine.Dial("missing")
+	assert.NotNil(t, err)
+	_, _, err = engine.Dial("bad")
+	assert.NotNil(t, err)
+	assert.Panics(t, func() { engine.Server(false, "base", "app", "missing") })
+
+	var rejected []string
+	engine.OnReject(func(path string, err error) {
+		rejected = append(rejected, path)
+	})
+	s, cfg, err := engine.NewServer(false, "base", "app", "1000")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:0", cfg.Addr)
+
+	// 非法的配置更新被拒绝,继续使用上一次有效的配置
+	conf.Set("/system/base/app/1000", "{")
+	assert.Equal(t, []string{"/system/base/app/1000"}, rejected)
+	assert.Equal(t, map[string]string{"version": "1"}, s.Metadata())
+
+	conf.Set("/system/base/app/1000", `{"network":"tcp","address":"127.0.0.1:1","timeout":"1s","tag":[{"key":"version","value":"2"}]}`)
+	assert.Len(t, rejected, 1)
+	assert.Equal(t, map[string]string{"version": "2"}, s.Metadata())
+}
+
+func TestEngineSharedConn(t *testing.T) {
+	conf := mock.NewConfiguration(map[string]string{
+		"/system/base/rpc/1000": `{"target":"127.0.0.1:1","timeout":"1s","nonBlock":true}`,
+		"/system/base/rpc/1001": `{"target":"127.0.0.1:1","timeout":"100ms","nonBlock":true}`,
+	})
+	engine := rpc.Engine("10000", conf)
+	conn, cfg, err := engine.Dial("1000")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:1", cfg.Target)
+	same, _, err := engine.Dial("1000")
+	assert.Nil(t, err)
+	assert.Same(t, conn, same)
+	// 同一systemId只注册一次配置监听
+	assert.Equal(t, 1, conf.Listeners("/system/base/rpc/1000"))
+
+	// 共享的连接始终使用首次Dial时的拦截器
+	var callers []string
+	logger := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			callers = append(callers, name)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	first, _, err := engine.Dial("1001", logger("a"))
+	assert.Nil(t, err)
+	shared, _, err := engine.Dial("1001", logger("b"))
+	assert.Nil(t, err)
+	assert.Same(t, first, shared)
+	_, _ = testproto.NewGreeterClient(shared).SayHello(context.Background(), &testproto.HelloRequest{Name: "shared"})
+	assert.Equal(t, []string{"a"}, callers)
+	assert.Nil(t, engine.Release("1001"))
+	assert.Nil(t, engine.Release("1001"))
+
+	// 共享的连接只应用一次配置更新
+	conf.Set("/system/base/rpc/1000", `{"target":"127.0.0.1:1","timeout":"2s","nonBlock":true}`)
+	_, cfg, _ = engine.Dial("1000")
+	assert.Equal(t, utils.Duration(time.Second*2), cfg.Timeout)
+
+	assert.Nil(t, engine.Release("1000"))
+	assert.Nil(t, engine.Release("1000"))
+	assert.NotEqual(t, connectivity.Shutdown, conn.GetState())
+	assert.Nil(t, engine.Release("1000"))
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+	assert.NotNil(t, engine.Release("1000"))
+
+	// 全部释放后重新创建连接
+	other, _, err := engine.Dial("1000")
+	assert.Nil(t, err)
+	assert.NotSame(t, conn, other)
+	assert.Nil(t, engine.Shutdown(context.Background()))
+	assert.Equal(t, connectivity.Shutdown, other.GetState())
+	// Shutdown之后的Release不做任何处理
+	assert.Nil(t, engine.Release("1000"))
+}
+
+func TestEngineLifecycle(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+
+	engine := rpc.Engine("10000", mock.NewConfiguration(map[string]string{
+		"/system/base/app/1000": fmt.Sprintf(`{"network":"tcp","address":"%s","timeout":"1s"}`, addr),
+		"/system/base/rpc/1000": fmt.Sprintf(`{"target":"%s","timeout":"1s"}`, addr),
+	}))
+	s, _, err := engine.NewServer(false, "base", "app", "1000")
+	assert.Nil(t, err)
+	testproto.RegisterGreeterServer(s.Server(), &slowServer{delay: time.Millisecond * 200})
+	assert.Nil(t, engine.Start())
+	// 已经启动的服务器不会重复启动
+	assert.Nil(t, engine.Start())
+
+	conn, _, err := engine.Dial("1000")
+	assert.Nil(t, err)
+	cli := testproto.NewGreeterClient(conn)
+	ch := make(chan error, 1)
+	go func() {
+		_, err := cli.SayHello(context.Background(), &testproto.HelloRequest{Name: "lifecycle"})
+		ch <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// 关闭时等待未完成的请求结束
+	assert.Nil(t, engine.Shutdown(context.Background()))
+	assert.Nil(t, <-ch)
+	_, err = cli.SayHello(context.Background(), &testproto.HelloRequest{Name: "lifecycle"})
+	assert.NotNil(t, err)
+}
+
+func TestEngineServerTags(t *testing.T) {
+	conf := mock.NewConfiguration(map[string]string{
+		"/system/base/app/1000": `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"region","value":"sh"}]}`,
+		"/system/base/app/1001": `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"region","value":"bj"}]}`,
+		"/system/base/app/1002": `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"region","value":"sh"}]}`,
+	})
+	engine := rpc.Engine("10000", conf)
+	defer engine.Shutdown(context.Background())
+	var rejected []string
+	engine.OnReject(func(path string, err error) {
+		rejected = append(rejected, path)
+	})
+	s, _, err := engine.NewServer(false, "base", "app", "1000")
+	assert.Nil(t, err)
+	// 服务端指标的常量标签由所有服务器共享,标签不同的服务器无法创建
+	_, _, err = engine.NewServer(false, "base", "app", "1001")
+	assert.NotNil(t, err)
+	_, _, err = engine.NewServer(false, "base", "app", "1002")
+	assert.Nil(t, err)
+
+	// 与其他服务器标签不同或者标签名称不合法的热更新被拒绝
+	conf.Set("/system/base/app/1000", `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"region","value":"bj"}]}`)
+	conf.Set("/system/base/app/1002", `{"network":"tcp","address":"127.0.0.1:0","timeout":"1s","tag":[{"key":"app.version","value":"1"}]}`)
+	assert.Equal(t, []string{"/system/base/app/1000", "/system/base/app/1002"}, rejected)
+	assert.Equal(t, map[string]string{"region": "sh"}, s.Metadata())
+}
+
+func TestEngineReleaseWhileDialing(t *testing.T) {
+	engine := rpc.Engine("10000", mock.NewConfiguration(map[string]string{
+		"/system/base/rpc/1000": `{"target":"127.0.0.1:1","timeout":"1s","dial":"200ms"}`,
+	}))
+	ch := make(chan error, 1)
+	go func() {
+		_, _, err := engine.Dial("1000")
+		ch <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	// 连接仍在创建中时释放,等待创建完成后再处理
+	assert.Nil(t, engine.Release("1000"))
+	assert.NotNil(t, <-ch)
+}
@@ -13,32 +13,35 @@ import (
 	"github.com/aluka-7/metacode"
 	"github.com/aluka-7/trace"
 	"github.com/aluka-7/utils"
-	"github.com/go-playground/validator/v10"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	_ "google.golang.org/grpc/encoding/gzip" // NOTE: use grpc gzip by header grpc-accept-encoding
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
 )
 
 var _abortIndex int8 = math.MaxInt8 / 2
 
 // ServerConfig 服务器配置信息
 type ServerConfig struct {
-	Network           string         `json:"network"`           // 网络为rpc监听网络，默认值为 tcp
-	Addr              string         `json:"address"`           // 地址是rpc监听地址，默认值为 0.0.0.0:9000
-	Timeout           utils.Duration `json:"timeout"`           // 超时是每个rpc调用的上下文超时。
-	IdleTimeout       utils.Duration `json:"idleTimeout"`       // IdleTimeout 是一段持续时间，在这段时间内可以通过发送 GoAway 关闭空闲连接。 空闲持续时间是自最近一次未完成RPC的数量变为零或建立连接以来定义的。
-	MaxLifeTime       utils.Duration `json:"maxLife"`           // MaxLifeTime 是连接通过发送GoAway关闭之前可能存在的最长时间的持续时间。 将向+/- 10％的随机抖动添加到MaxConnectionAge中以分散连接风暴.
-	ForceCloseWait    utils.Duration `json:"closeWait"`         // ForceCloseWait 是 MaxLifeTime 之后的附加时间，在此之后将强制关闭连接。
-	KeepAliveInterval utils.Duration `json:"keepaliveInterval"` // 如果服务器没有看到任何活动，则 KeepAliveInterval 将在此时间段之后，对客户端进行ping操作以查看传输是否仍然有效。
-	KeepAliveTimeout  utils.Duration `json:"keepaliveTimeout"`  // 进行 keepalive 检查 ping 之后，服务器将等待一段时间的超时，并且即使在关闭连接后也看不到活动。
-	EnableLog         bool           `json:"enableLog"`         // 是否打开日志
+	Network           string                     `json:"network"`           // 网络为rpc监听网络，默认值为 tcp
+	Addr              string                     `json:"address"`           // 地址是rpc监听地址，默认值为 0.0.0.0:9000
+	Timeout           utils.Duration             `json:"timeout"`           // 超时是每个rpc调用的上下文超时。
+	IdleTimeout       utils.Duration             `json:"idleTimeout"`       // IdleTimeout 是一段持续时间，在这段时间内可以通过发送 GoAway 关闭空闲连接。 空闲持续时间是自最近一次未完成RPC的数量变为零或建立连接以来定义的。
+	MaxLifeTime       utils.Duration             `json:"maxLife"`           // MaxLifeTime 是连接通过发送GoAway关闭之前可能存在的最长时间的持续时间。 将向+/- 10％的随机抖动添加到MaxConnectionAge中以分散连接风暴.
+	ForceCloseWait    utils.Duration             `json:"closeWait"`         // ForceCloseWait 是 MaxLifeTime 之后的附加时间，在此之后将强制关闭连接。
+	KeepAliveInterval utils.Duration             `json:"keepaliveInterval"` // 如果服务器没有看到任何活动，则 KeepAliveInterval 将在此时间段之后，对客户端进行ping操作以查看传输是否仍然有效。
+	KeepAliveTimeout  utils.Duration             `json:"keepaliveTimeout"`  // 进行 keepalive 检查 ping 之后，服务器将等待一段时间的超时，并且即使在关闭连接后也看不到活动。
+	EnableLog         bool                       `json:"enableLog"`         // 是否打开日志
+	Locale            string                     `json:"locale"`            // 参数校验错误提示信息的默认语言,默认值为 zh,客户端可通过 accept-language 元数据指定
+	CodeMetric        *CodeMetricConfig          `json:"codeMetric"`        // 错误码监控配置,用于错误码分类以及限制标签基数
+	Deadline          *DeadlinePolicy            `json:"deadline"`          // 截止时间传递策略,默认为响应预留 20ms
+	Limiter           *LimiterConfig             `json:"limiter"`           // 并发限制配置,超出并发上限时按照请求优先级排队或者拒绝
+	Idempotency       *IdempotencyConfig         `json:"idempotency"`       // 幂等配置,对携带相同幂等键的请求返回保存的执行结果
+	Coalesce          map[string]*CoalesceConfig `json:"coalesce"`          // 按照方法全名配置的请求合并,并发的相同请求只执行一次
+	Fault             *FaultConfig               `json:"fault"`             // 故障注入配置,默认关闭
 }
 
 // Server 是框架的服务器端实例，它包含RpcServer，拦截器和拦截器。
@@ -48,6 +51,13 @@ type Server struct {
 	mutex    sync.RWMutex
 	server   *grpc.Server
 	handlers []grpc.UnaryServerInterceptor
+	hooks    []errorHook
+	labeler  codeLabeler
+	limiter  limiter
+
+	idempotent idempotent
+	flights    flightGroup
+	tags       []trace.Tag
 }
 
 // handle为OpenTracing\Logging\LinkTimeout返回一个新的一元服务器拦截器。
@@ -55,22 +65,17 @@ func (s *Server) handle() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		// 对于性能进行监测等等
 		s.mutex.RLock()
-		conf := s.conf
+		conf, tags := s.conf, s.tags
 		s.mutex.RUnlock()
-		// 从rpc上下文获取派生超时，与配置的看守进行比较，并使用最小值
-		timeout := time.Duration(conf.Timeout)
-		if dl, ok := ctx.Deadline(); ok {
-			_timeout := time.Until(dl)
-			if _timeout-time.Millisecond*20 > 0 {
-				_timeout = _timeout - time.Millisecond*20
-			}
-			if timeout > _timeout {
-				timeout = _timeout
-			}
+		// 从rpc上下文获取派生超时，按照截止时间策略预留时间后与配置的看守进行比较，并使用最小值
+		// 剩余时间低于下限时由日志拦截器之后的deadline拦截器拒绝,使拒绝同样计入监控以及错误钩子
+		if timeout, ok := conf.Deadline.timeout(ctx, args.FullMethod, time.Duration(conf.Timeout)); ok {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		} else {
+			ctx = context.WithValue(ctx, deadlineRejected{}, true)
 		}
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		defer cancel()
 
 		// 获取rpc元数据(trace＆remote_ip＆color)
 		var t trace.Trace
@@ -78,7 +83,7 @@ func (s *Server) handle() grpc.UnaryServerInterceptor {
 		if gmd, ok := metadata.FromIncomingContext(ctx); ok {
 			t, _ = trace.Extract(trace.GRPCFormat, gmd)
 			for k, v := range gmd {
-				if metacode.IsIncomingKey(k) {
+				if metacode.IsIncomingKey(k) || k == Color {
 					cmd[k] = v[0]
 				}
 			}
@@ -88,6 +93,9 @@ func (s *Server) handle() grpc.UnaryServerInterceptor {
 		} else {
 			t.SetTitle(args.FullMethod)
 		}
+		if len(tags) > 0 {
+			t.SetTag(tags...)
+		}
 
 		var addr string
 		if pr, ok := peer.FromContext(ctx); ok {
@@ -118,19 +126,17 @@ func NewServer(conf *ServerConfig, opt ...grpc.ServerOption) (s *Server) {
 		Timeout:               time.Duration(s.conf.KeepAliveTimeout),
 		MaxConnectionAge:      time.Duration(s.conf.MaxLifeTime),
 	})
-	opt = append(opt, keepParam, grpc.UnaryInterceptor(s.interceptor))
+	opt = append(opt, keepParam, grpc.UnaryInterceptor(s.interceptor), grpc.ChainStreamInterceptor(s.streamValidate()))
 	s.server = grpc.NewServer(opt...)
-	s.Use(s.recovery(), s.handle(), s.serverLogging(), s.validate())
+	s.Use(s.recovery(), s.handle(), s.serverLogging(), s.deadline(), s.fault(), s.limit(), s.idempotency(), s.validate(), s.coalesce())
 	return
 }
 
-// SetConfig 热重载服务器配置
+// SetConfig 热重载服务器配置,配置会先填充默认值并校验,不合法时返回错误并继续使用原配置.
 func (s *Server) SetConfig(conf *ServerConfig) (err error) {
-	if conf.Addr == "" {
-		log.Warn().Msg("ServerConfig Addr is not empty")
-	}
-	if conf.Network == "" {
-		log.Warn().Msg("ServerConfig Network is not empty")
+	conf = conf.Defaults()
+	if err = conf.Validate(); err != nil {
+		return
 	}
 	s.mutex.Lock()
 	s.conf = conf
@@ -263,27 +269,6 @@ func (s *Server) Shutdown(ctx context.Context) (err error) {
 	return
 }
 
-var validate = validator.New()
-
-// 验证返回一个客户端拦截器,以验证每个RPC调用的传入请求.
-func (s *Server) validate() grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		if err = validate.Struct(req); err != nil {
-			err = metacode.Error(metacode.ValidateErr, err.Error())
-			return
-		}
-		resp, err = handler(ctx, req)
-		return
-	}
-}
-
-// RegisterValidation 将验证功能添加到由键表示的验证者的验证者映射中
-// 注意:如果密钥已经存在,则先前的验证功能将被替换。
-// 注意:此方法不是线程安全的,因此应在进行任何验证之前先将它们全部注册
-func (s *Server) RegisterValidation(key string, fn validator.Func) error {
-	return validate.RegisterValidation(key, fn)
-}
-
 // recovery是从任何紧急情况中恢复的服务器拦截器。
 func (s *Server) recovery() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
@@ -298,7 +283,7 @@ func (s *Server) recovery() grpc.UnaryServerInterceptor {
 				buf = buf[:rs]
 				pl := fmt.Sprintf("grpc server panic: %v\n%v\n%s\n", req, _err, buf)
 				fmt.Fprintf(os.Stderr, pl)
-				err = status.Errorf(codes.Unknown, metacode.ServerErr.Error())
+				err = FromError(metacode.ServerErr).Err()
 			}
 		}()
 		resp, err = handler(ctx, req)
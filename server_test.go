@@ -166,6 +166,9 @@ func testValidation(t *testing.T) {
 	if !nmd.EqualError(nmd.ValidateErr, err) {
 		t.Fatalf("testValidation should return nmd.RequestErr,but is %v", err)
 	}
+	if v := FieldViolations(err); v["name"] == "" {
+		t.Fatalf("testValidation should return field violation of name,but is %v", v)
+	}
 }
 
 func testTimeoutOpt(t *testing.T) {
@@ -413,6 +416,11 @@ func (t *testServer) StreamHello(pb.Greeter_StreamHelloServer) error { panic("no
 
 // NewTestServerClient .
 func NewTestServerClient(invoker func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error), svrcfg *ServerConfig, clicfg *ClientConfig) (pb.GreeterClient, func() error) {
+	_, cli, cancel := newTestServerClient(invoker, svrcfg, clicfg)
+	return cli, cancel
+}
+
+func newTestServerClient(invoker func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error), svrcfg *ServerConfig, clicfg *ClientConfig) (*Server, pb.GreeterClient, func() error) {
 	srv := NewServer(svrcfg)
 	pb.RegisterGreeterServer(srv.Server(), &testServer{helloFn: invoker})
 
@@ -431,7 +439,7 @@ func NewTestServerClient(invoker func(ctx context.Context, req *pb.HelloRequest)
 	if err != nil {
 		panic(err)
 	}
-	return pb.NewGreeterClient(conn), func() error { return srv.Shutdown(context.Background()) }
+	return srv, pb.NewGreeterClient(conn), func() error { return srv.Shutdown(context.Background()) }
 }
 
 func TestMetadata(t *testing.T) {
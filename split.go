@@ -0,0 +1,229 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/aluka-7/metacode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TargetConfig 流量拆分的目标配置,用于在稳定版本与灰度版本之间按照规则或者权重拆分流量.
+type TargetConfig struct {
+	Name   string     `json:"name"`   // 目标名称,用于监控,为空时使用目标地址
+	Target string     `json:"target"` // 目标地址,与 RpcClientConfig.Target 相同时使用主连接
+	Weight int        `json:"weight"` // 不匹配任何规则的请求按照权重随机选择目标
+	Match  *MatchRule `json:"match"`  // 匹配规则,匹配的请求全部路由到该目标
+}
+
+// MatchRule 流量拆分的匹配规则,所有非空的条件都满足时匹配.
+type MatchRule struct {
+	Caller string            `json:"caller"` // 调用方,优先使用链路中传递的调用方,否则使用当前应用
+	Method string            `json:"method"` // 方法全名,以*结尾时按照前缀匹配
+	Header map[string]string `json:"header"` // 请求元数据
+}
+
+// matchMethod 判断方法是否匹配,pattern以*结尾时按照前缀匹配,为空时匹配所有方法
+func matchMethod(pattern, method string) bool {
+	if pattern == "" || pattern == method {
+		return true
+	}
+	return strings.HasSuffix(pattern, "*") && strings.HasPrefix(method, strings.TrimSuffix(pattern, "*"))
+}
+
+func (m *MatchRule) match(ctx context.Context, method, caller string) bool {
+	if m.Caller != "" && m.Caller != caller {
+		return false
+	}
+	if !matchMethod(m.Method, method) {
+		return false
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for k, v := range m.Header {
+		if vs := md.Get(k); len(vs) > 0 {
+			if vs[0] != v {
+				return false
+			}
+		} else if metacode.ToString(ctx, k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedConn 引用计数的连接,被移除后在所有使用中的请求完成时才关闭
+type sharedConn struct {
+	conn    *grpc.ClientConn
+	mutex   sync.Mutex
+	refs    int
+	retired bool
+}
+
+// acquire 增加引用计数,必须在持有移除该连接时使用的锁时调用
+func (sc *sharedConn) acquire() *grpc.ClientConn {
+	sc.mutex.Lock()
+	sc.refs++
+	sc.mutex.Unlock()
+	return sc.conn
+}
+
+func (sc *sharedConn) release() {
+	sc.mutex.Lock()
+	sc.refs--
+	closing := sc.retired && sc.refs == 0
+	sc.mutex.Unlock()
+	if closing {
+		_ = sc.conn.Close()
+	}
+}
+
+// retire 移除连接,没有使用中的请求时立即关闭
+func (sc *sharedConn) retire() {
+	sc.mutex.Lock()
+	sc.retired = true
+	closing := sc.refs == 0
+	sc.mutex.Unlock()
+	if closing {
+		_ = sc.conn.Close()
+	}
+}
+
+// router 流量拆分的状态
+type router struct {
+	mutex   sync.RWMutex
+	targets []*TargetConfig
+	conns   map[string]*sharedConn
+}
+
+// SetTargets 热更新流量拆分配置,只为新增的目标建立连接并关闭移除的目标,未变化的目标不会重新连接.
+// 目标的连接使用与主连接相同的选项,主连接尚未建立时在建立后再连接,移除的目标在使用中的请求完成后关闭.
+func (c *Client) SetTargets(targets []*TargetConfig) error {
+	c.router.mutex.Lock()
+	defer c.router.mutex.Unlock()
+	conns := make(map[string]*sharedConn, len(targets))
+	var err error
+	for _, t := range targets {
+		if _, ok := conns[t.Target]; ok {
+			continue
+		}
+		if sc, ok := c.router.conns[t.Target]; ok {
+			conns[t.Target] = sc
+			continue
+		}
+		conn, derr := c.dialSide(t.Target)
+		if derr != nil {
+			if err == nil {
+				err = derr
+			}
+			continue
+		}
+		if conn != nil {
+			conns[t.Target] = &sharedConn{conn: conn}
+		}
+	}
+	for target, sc := range c.router.conns {
+		if _, ok := conns[target]; !ok {
+			sc.retire()
+		}
+	}
+	c.router.targets, c.router.conns = targets, conns
+	return err
+}
+
+// connectSides 主连接建立后为已经配置的流量拆分目标以及流量镜像建立连接
+func (c *Client) connectSides() {
+	c.connectMirror()
+	c.router.mutex.RLock()
+	targets := c.router.targets
+	c.router.mutex.RUnlock()
+	if len(targets) > 0 {
+		if err := c.SetTargets(targets); err != nil {
+			fmt.Printf("RPC流量拆分连接出错:%+v\n", err)
+		}
+	}
+}
+
+// pick 选择请求的目标,优先使用匹配规则的目标,否则按照权重随机选择
+func (r *router) pick(ctx context.Context, method, caller string) *TargetConfig {
+	var total int
+	for _, t := range r.targets {
+		if t.Match != nil && t.Match.match(ctx, method, caller) {
+			return t
+		}
+		if t.Weight > 0 {
+			total += t.Weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for _, t := range r.targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		if n -= t.Weight; n < 0 {
+			return t
+		}
+	}
+	return nil
+}
+
+// split 返回流量拆分的客户端拦截器,将请求交给所选目标的连接执行,后续的拦截器对所有目标同样生效.
+func (c *Client) split(caller []string) grpc.UnaryClientInterceptor {
+	var self string
+	if len(caller) > 0 {
+		self = caller[0]
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c.router.mutex.RLock()
+		if len(c.router.targets) == 0 {
+			c.router.mutex.RUnlock()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		from := metacode.ToString(ctx, metacode.Caller)
+		if from == "" {
+			from = self
+		}
+		t := c.router.pick(ctx, method, from)
+		if t == nil {
+			c.router.mutex.RUnlock()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		conn := cc
+		if t.Target != cc.Target() {
+			if sc := c.router.conns[t.Target]; sc != nil {
+				conn = sc.acquire()
+				defer sc.release()
+			} else {
+				// 目标的连接建立失败时回退到主连接,按照实际处理请求的主连接计数
+				t = c.router.primary(cc.Target())
+			}
+		}
+		c.router.mutex.RUnlock()
+		metricClientSplitTotal.Inc(method, t.label())
+		return invoker(ctx, method, req, reply, conn, opts...)
+	}
+}
+
+// primary 返回主连接对应的目标,没有配置时使用主连接的地址
+func (r *router) primary(target string) *TargetConfig {
+	for _, t := range r.targets {
+		if t.Target == target {
+			return t
+		}
+	}
+	return &TargetConfig{Target: target}
+}
+
+// label 返回目标在监控中的名称
+func (t *TargetConfig) label() string {
+	if t.Name == "" {
+		return t.Target
+	}
+	return t.Name
+}
@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTrafficSplit(t *testing.T) {
+	newServer := func(name string) (string, func()) {
+		return startTestServer(func(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+			return &pb.HelloReply{Success: true, Message: name}, nil
+		})
+	}
+	stable, stopStable := newServer("stable")
+	defer stopStable()
+	canary, stopCanary := newServer("canary")
+	defer stopCanary()
+
+	client := NewClient(&ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)})
+	// 主连接建立前配置的目标在主连接建立后使用相同的选项连接
+	assert.Nil(t, client.SetTargets([]*TargetConfig{{Target: canary, Weight: 100}}))
+	assert.Empty(t, client.router.conns)
+	conn, err := client.Dial(context.Background(), stable, []string{"10000"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	defer client.SetTargets(nil)
+	cli := pb.NewGreeterClient(conn)
+	call := func(ctx context.Context) string {
+		reply, err := cli.SayHello(ctx, &pb.HelloRequest{Name: "split"})
+		assert.Nil(t, err)
+		return reply.Message
+	}
+	assert.NotNil(t, client.router.conns[canary])
+	assert.Equal(t, "canary", call(context.Background()))
+
+	assert.Nil(t, client.SetTargets([]*TargetConfig{
+		{Name: "stable", Target: stable, Weight: 100},
+		{Name: "canary", Target: canary, Match: &MatchRule{Header: map[string]string{"x-canary": "1"}}},
+	}))
+	canaryConn := client.router.conns[canary]
+	assert.Equal(t, "stable", call(context.Background()))
+	assert.Equal(t, "canary", call(metadata.AppendToOutgoingContext(context.Background(), "x-canary", "1")))
+
+	// 热更新权重时不会重新连接未变化的目标
+	assert.Nil(t, client.SetTargets([]*TargetConfig{
+		{Name: "stable", Target: stable, Weight: 0},
+		{Name: "canary", Target: canary, Weight: 100},
+	}))
+	assert.Same(t, canaryConn, client.router.conns[canary])
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "canary", call(context.Background()))
+	}
+
+	assert.Nil(t, client.SetTargets([]*TargetConfig{
+		{Name: "canary", Target: canary, Match: &MatchRule{Caller: "10000", Method: "/testproto.Greeter/*"}},
+	}))
+	assert.Equal(t, "canary", call(context.Background()))
+
+	// 目标的连接建立失败时回退到主连接,请求计入主连接
+	const method = "/testproto.Greeter/SayHello"
+	client.router.mutex.Lock()
+	targets, conns := client.router.targets, client.router.conns
+	client.router.targets = []*TargetConfig{{Name: "broken", Target: "127.0.0.1:1", Weight: 100}}
+	client.router.conns = map[string]*sharedConn{}
+	client.router.mutex.Unlock()
+	broken := counterValue("rpc_client_split_requests_total", map[string]string{"method": method, "target": "broken"})
+	primary := counterValue("rpc_client_split_requests_total", map[string]string{"method": method, "target": stable})
+	assert.Equal(t, "stable", call(context.Background()))
+	assert.Equal(t, broken, counterValue("rpc_client_split_requests_total", map[string]string{"method": method, "target": "broken"}))
+	assert.Equal(t, primary+1, counterValue("rpc_client_split_requests_total", map[string]string{"method": method, "target": stable}))
+	client.router.mutex.Lock()
+	client.router.targets, client.router.conns = targets, conns
+	client.router.mutex.Unlock()
+
+	// 移除目标后关闭其连接
+	assert.Nil(t, client.SetTargets(nil))
+	assert.Equal(t, "stable", call(context.Background()))
+	assert.Empty(t, client.router.conns)
+	assert.Equal(t, connectivity.Shutdown, canaryConn.conn.GetState())
+}
+
+func TestSharedConnRetire(t *testing.T) {
+	conn, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	assert.Nil(t, err)
+	sc := &sharedConn{conn: conn}
+	sc.acquire()
+	// 使用中的连接被移除后在请求完成时关闭
+	sc.retire()
+	assert.NotEqual(t, connectivity.Shutdown, conn.GetState())
+	sc.release()
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+}
@@ -3,6 +3,7 @@ package grpc
 import (
 	"context"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -10,55 +11,98 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/metacode/types"
 )
 
+// metacode中没有与之对应的通用错误码的gRPC状态码,在此补充定义.
+var (
+	FailedPrecondition = metacode.Code(-412) // 前置条件不满足
+	OutOfRange         = metacode.Code(-416) // 超出有效范围
+	Aborted            = metacode.Code(-419) // 操作被中止,通常是并发冲突
+	DataLoss           = metacode.Code(-510) // 数据丢失或损坏
+)
+
+// CodeRange 将[Min,Max]范围内的业务错误码映射为指定的gRPC状态码.
+type CodeRange struct {
+	Min  int        `json:"min"`
+	Max  int        `json:"max"`
+	Code codes.Code `json:"code"` // 支持数字或者"NOT_FOUND"等名称
+}
+
+var (
+	// metacode -> gRPC状态码
+	_toGRPCCodes = map[int]codes.Code{
+		metacode.OK.Code():                 codes.OK,
+		metacode.Success.Code():            codes.OK,
+		metacode.RequestErr.Code():         codes.InvalidArgument,
+		metacode.ValidateErr.Code():        codes.InvalidArgument,
+		metacode.Unauthorized.Code():       codes.Unauthenticated,
+		metacode.AccessDenied.Code():       codes.PermissionDenied,
+		metacode.NothingFound.Code():       codes.NotFound,
+		metacode.MethodNotAllowed.Code():   codes.Unimplemented,
+		metacode.Conflict.Code():           codes.AlreadyExists,
+		metacode.Canceled.Code():           codes.Canceled,
+		metacode.ServerErr.Code():          codes.Internal,
+		metacode.ServiceUnavailable.Code(): codes.Unavailable,
+		metacode.Deadline.Code():           codes.DeadlineExceeded,
+		metacode.LimitExceed.Code():        codes.ResourceExhausted,
+		FailedPrecondition.Code():          codes.FailedPrecondition,
+		OutOfRange.Code():                  codes.OutOfRange,
+		Aborted.Code():                     codes.Aborted,
+		DataLoss.Code():                    codes.DataLoss,
+	}
+	// gRPC状态码 -> metacode
+	_toMetaCodes = map[codes.Code]metacode.Code{
+		codes.OK:                 metacode.OK,
+		codes.Canceled:           metacode.Canceled,
+		codes.InvalidArgument:    metacode.RequestErr,
+		codes.DeadlineExceeded:   metacode.Deadline,
+		codes.NotFound:           metacode.NothingFound,
+		codes.AlreadyExists:      metacode.Conflict,
+		codes.PermissionDenied:   metacode.AccessDenied,
+		codes.ResourceExhausted:  metacode.LimitExceed,
+		codes.FailedPrecondition: FailedPrecondition,
+		codes.Aborted:            Aborted,
+		codes.OutOfRange:         OutOfRange,
+		codes.Unimplemented:      metacode.MethodNotAllowed,
+		codes.Internal:           metacode.ServerErr,
+		codes.Unavailable:        metacode.ServiceUnavailable,
+		codes.DataLoss:           DataLoss,
+		codes.Unauthenticated:    metacode.Unauthorized,
+	}
+	_codeRanges atomic.Value // NOTE: stored []CodeRange
+)
+
+// SetCodeRanges 设置业务错误码范围到gRPC状态码的映射,会替换之前的设置,可用于配置热更新.
+// 通用错误码的映射优先于自定义范围,未匹配任何范围的业务错误码映射为codes.Unknown.
+// 映射是进程级的设置,对所有服务器和客户端生效,不属于 ServerConfig 或 ClientConfig,配置中心推送的配置不会修改映射;
+// 需要从配置读取时由应用自行解析 CodeRange 并调用本方法,范围重叠时使用先出现的范围.
+func SetCodeRanges(ranges ...CodeRange) {
+	rs := make([]CodeRange, len(ranges))
+	copy(rs, ranges)
+	_codeRanges.Store(rs)
+}
+
 // 将metacode.Codes转换为gRPC代码
 func togRrcCode(code metacode.Codes) codes.Code {
-	switch code.Code() {
-	case metacode.OK.Code():
-		return codes.OK
-	case metacode.RequestErr.Code():
-		return codes.InvalidArgument
-	case metacode.NothingFound.Code():
-		return codes.NotFound
-	case metacode.Unauthorized.Code():
-		return codes.Unauthenticated
-	case metacode.AccessDenied.Code():
-		return codes.PermissionDenied
-	case metacode.LimitExceed.Code():
-		return codes.ResourceExhausted
-	case metacode.MethodNotAllowed.Code():
-		return codes.Unimplemented
-	case metacode.Deadline.Code():
-		return codes.DeadlineExceeded
-	case metacode.ServiceUnavailable.Code():
-		return codes.Unavailable
+	if gc, ok := _toGRPCCodes[code.Code()]; ok {
+		return gc
+	}
+	if rs, ok := _codeRanges.Load().([]CodeRange); ok {
+		for _, r := range rs {
+			if code.Code() >= r.Min && code.Code() <= r.Max {
+				return r.Code
+			}
+		}
 	}
 	return codes.Unknown
 }
 
 func toMetaCode(gst *status.Status) metacode.Code {
-	code := gst.Code()
-	switch code {
-	case codes.OK:
-		return metacode.OK
-	case codes.InvalidArgument:
-		return metacode.RequestErr
-	case codes.NotFound:
-		return metacode.NothingFound
-	case codes.PermissionDenied:
-		return metacode.AccessDenied
-	case codes.Unauthenticated:
-		return metacode.Unauthorized
-	case codes.ResourceExhausted:
-		return metacode.LimitExceed
-	case codes.Unimplemented:
-		return metacode.MethodNotAllowed
-	case codes.DeadlineExceeded:
-		return metacode.Deadline
-	case codes.Unavailable:
-		return metacode.ServiceUnavailable
-	case codes.Unknown:
+	if code, ok := _toMetaCodes[gst.Code()]; ok {
+		return code
+	}
+	if gst.Code() == codes.Unknown {
 		return metacode.String(gst.Message())
 	}
 	return metacode.ServerErr
@@ -102,18 +146,34 @@ func gRPCStatusFromMetaCode(code metacode.Codes) (*status.Status, error) {
 			}
 		}
 	}
-	gst := status.New(codes.Unknown, strconv.Itoa(st.Code()))
-	return gst.WithDetails(st.Proto())
+	// google.rpc标准错误详情同时附加在gRPC状态中,便于非Go客户端以及代理识别
+	details := []proto.Message{st.Proto()}
+	for _, detail := range st.Details() {
+		if msg, ok := detail.(proto.Message); ok && isStandardDetail(msg) {
+			details = append(details, msg)
+		}
+	}
+	gst := status.New(togRrcCode(st), strconv.Itoa(st.Code()))
+	return gst.WithDetails(details...)
 }
 
 // 将grpc.status转换为metacode.Codes
 func ToMetaCode(gst *status.Status) metacode.Codes {
-	details := gst.Details()
-	for _, detail := range details {
-		// 将详细信息转换为状态,仅使用第一个详细信息
-		if pb, ok := detail.(proto.Message); ok {
+	var details []proto.Message
+	for _, detail := range gst.Details() {
+		switch pb := detail.(type) {
+		case *types.Status:
+			// 将详细信息转换为状态,仅使用第一个metacode状态
 			return metacode.FromProto(pb)
+		case proto.Message:
+			details = append(details, pb)
 		}
 	}
-	return toMetaCode(gst)
+	code := toMetaCode(gst)
+	if len(details) == 0 {
+		return code
+	}
+	// 非metacode服务端返回的错误详情(例如errdetails)保留在metacode状态中
+	st, _ := metacode.Error(code, gst.Message()).WithDetails(details...)
+	return st
 }
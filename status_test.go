@@ -2,6 +2,7 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -17,22 +18,22 @@ import (
 
 func TestCodeConvert(t *testing.T) {
 	var table = map[codes.Code]metacode.Code{
-		codes.OK: metacode.OK,
-		// codes.Canceled
-		codes.Unknown:          metacode.ServerErr,
-		codes.InvalidArgument:  metacode.RequestErr,
-		codes.DeadlineExceeded: metacode.Deadline,
-		codes.NotFound:         metacode.NothingFound,
-		// codes.AlreadyExists
-		codes.PermissionDenied:  metacode.AccessDenied,
-		codes.ResourceExhausted: metacode.LimitExceed,
-		// codes.FailedPrecondition
-		// codes.Aborted
-		// codes.OutOfRange
-		codes.Unimplemented: metacode.MethodNotAllowed,
-		codes.Unavailable:   metacode.ServiceUnavailable,
-		// codes.DataLoss
-		codes.Unauthenticated: metacode.Unauthorized,
+		codes.OK:                 metacode.OK,
+		codes.Canceled:           metacode.Canceled,
+		codes.InvalidArgument:    metacode.RequestErr,
+		codes.DeadlineExceeded:   metacode.Deadline,
+		codes.NotFound:           metacode.NothingFound,
+		codes.AlreadyExists:      metacode.Conflict,
+		codes.PermissionDenied:   metacode.AccessDenied,
+		codes.ResourceExhausted:  metacode.LimitExceed,
+		codes.FailedPrecondition: FailedPrecondition,
+		codes.Aborted:            Aborted,
+		codes.OutOfRange:         OutOfRange,
+		codes.Unimplemented:      metacode.MethodNotAllowed,
+		codes.Internal:           metacode.ServerErr,
+		codes.Unavailable:        metacode.ServiceUnavailable,
+		codes.DataLoss:           DataLoss,
+		codes.Unauthenticated:    metacode.Unauthorized,
 	}
 	for k, v := range table {
 		assert.Equal(t, toMetaCode(status.New(k, "-500")), v)
@@ -40,6 +41,32 @@ func TestCodeConvert(t *testing.T) {
 	for k, v := range table {
 		assert.Equal(t, togRrcCode(v), k, fmt.Sprintf("togRPC code error: %d -> %d", v, k))
 	}
+	assert.Equal(t, metacode.ServerErr, toMetaCode(status.New(codes.Unknown, "-500")))
+	assert.Equal(t, codes.InvalidArgument, togRrcCode(metacode.ValidateErr))
+}
+
+func TestCodeRanges(t *testing.T) {
+	defer SetCodeRanges()
+	assert.Equal(t, codes.Unknown, togRrcCode(metacode.Code(10404)))
+
+	SetCodeRanges(CodeRange{Min: 10400, Max: 10499, Code: codes.NotFound}, CodeRange{Min: 20000, Max: 29999, Code: codes.FailedPrecondition})
+	assert.Equal(t, codes.NotFound, togRrcCode(metacode.Code(10404)))
+	assert.Equal(t, codes.FailedPrecondition, togRrcCode(metacode.Code(20001)))
+	assert.Equal(t, codes.Unknown, togRrcCode(metacode.Code(30001)))
+	// 通用错误码的映射不受自定义范围影响
+	SetCodeRanges(CodeRange{Min: -1000, Max: 1000, Code: codes.Aborted})
+	assert.Equal(t, codes.InvalidArgument, togRrcCode(metacode.RequestErr))
+
+	var cr CodeRange
+	assert.Nil(t, json.Unmarshal([]byte(`{"min":1,"max":2,"code":"NOT_FOUND"}`), &cr))
+	assert.Equal(t, codes.NotFound, cr.Code)
+
+	SetCodeRanges(CodeRange{Min: 10400, Max: 10499, Code: codes.NotFound})
+	gst := FromError(metacode.Error(metacode.Code(10404), "user not found"))
+	assert.Equal(t, codes.NotFound, gst.Code())
+	ec := ToMetaCode(gst)
+	assert.Equal(t, 10404, ec.Code())
+	assert.Equal(t, "user not found", ec.Message())
 }
 
 func TestNoDetailsConvert(t *testing.T) {
@@ -68,22 +95,20 @@ func TestFromError(t *testing.T) {
 		err := metacode.RequestErr
 		gst := FromError(err)
 
-		// assert.Equal(t, codes.InvalidArgument, gst.Code())
-		// NOTE: set all grpc.status as Unknown when error is metacode.Codes for compatible
-		assert.Equal(t, codes.Unknown, gst.Code())
+		assert.Equal(t, codes.InvalidArgument, gst.Code())
 		// NOTE: gst.Message == str(metacode.Code) for compatible php leagcy code
 		assert.Equal(t, err.Message(), gst.Message())
 	})
 	t.Run("input raw Canceled", func(t *testing.T) {
 		gst := FromError(context.Canceled)
 
-		assert.Equal(t, codes.Unknown, gst.Code())
+		assert.Equal(t, codes.Canceled, gst.Code())
 		assert.Equal(t, "-498", gst.Message())
 	})
 	t.Run("input raw DeadlineExceeded", func(t *testing.T) {
 		gst := FromError(context.DeadlineExceeded)
 
-		assert.Equal(t, codes.Unknown, gst.Code())
+		assert.Equal(t, codes.DeadlineExceeded, gst.Code())
 		assert.Equal(t, "-504", gst.Message())
 	})
 	t.Run("input metacode.Status", func(t *testing.T) {
@@ -91,8 +116,7 @@ func TestFromError(t *testing.T) {
 		err, _ := metacode.Error(metacode.Unauthorized, "unauthorized").WithDetails(m)
 		gst := FromError(err)
 
-		// NOTE: set all grpc.status as Unknown when error is metacode.Codes for compatible
-		assert.Equal(t, codes.Unknown, gst.Code())
+		assert.Equal(t, codes.Unauthenticated, gst.Code())
 		assert.Len(t, gst.Details(), 1)
 		details := gst.Details()
 		assert.IsType(t, err.Proto(), details[0])
@@ -0,0 +1,252 @@
+package grpc
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/aluka-7/metric"
+	"github.com/aluka-7/trace"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SetTags 设置服务器的标签(例如region、cluster、version),标签会添加到该服务器的每个链路追踪Span上,
+// 并通过 Metadata 提供给服务注册使用;引擎创建的服务器的标签同时作为服务端指标的常量标签,
+// 直接使用 Server 时通过进程级的 SetMetricLabels 设置.
+func (s *Server) SetTags(tags ...trace.Tag) {
+	s.mutex.Lock()
+	s.tags = tags
+	s.mutex.Unlock()
+}
+
+// Metadata 返回服务器的标签,用于发布到服务注册的元数据中.
+func (s *Server) Metadata() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return tagValues(s.tags)
+}
+
+// tagValues 将标签转换为字符串
+func tagValues(tags []trace.Tag) map[string]string {
+	values := make(map[string]string, len(tags))
+	for _, t := range tags {
+		values[t.Key] = fmt.Sprint(t.Value)
+	}
+	return values
+}
+
+// validLabelName 判断是否为合法的指标标签名称,以__开头的名称为prometheus保留
+func validLabelName(name string) bool {
+	if name == "" || (len(name) >= 2 && name[:2] == "__") {
+		return false
+	}
+	for i, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9' && i > 0)) {
+			return false
+		}
+	}
+	return true
+}
+
+// serverMetric 服务端指标以及其变量标签
+type serverMetric struct {
+	collector prometheus.Collector
+	labels    []string
+}
+
+// serverMetrics 服务端指标的收集器,采集时为所有服务端指标添加常量标签.
+// 常量标签会改变指标的维度,因此作为不检查描述的收集器只注册一次,标签变化时不需要重新注册.
+type serverMetrics struct {
+	mutex   sync.RWMutex
+	metrics []serverMetric
+	labels  prometheus.Labels
+	wrapped []prometheus.Collector // 添加了常量标签的服务端指标
+}
+
+var _serverMetrics = new(serverMetrics)
+
+func init() {
+	prometheus.MustRegister(_serverMetrics)
+}
+
+// Describe 不提供描述,服务端指标的维度随常量标签变化
+func (m *serverMetrics) Describe(chan<- *prometheus.Desc) {}
+
+func (m *serverMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, c := range m.wrapped {
+		c.Collect(ch)
+	}
+}
+
+// wrap 为服务端指标添加常量标签,必须在持有锁时调用
+func (m *serverMetrics) wrap(labels prometheus.Labels) []prometheus.Collector {
+	r := new(collectorRegisterer)
+	wr := prometheus.WrapRegistererWith(labels, r)
+	for _, sm := range m.metrics {
+		wr.MustRegister(sm.collector)
+	}
+	return r.collectors
+}
+
+// register 注册服务端指标
+func (m *serverMetrics) register(c prometheus.Collector, labels []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.metrics = append(m.metrics, serverMetric{collector: c, labels: labels})
+	m.wrapped = m.wrap(m.labels)
+}
+
+// collectorRegisterer 记录注册的收集器,用于获取添加了常量标签的收集器
+type collectorRegisterer struct {
+	collectors []prometheus.Collector
+}
+
+func (r *collectorRegisterer) Register(c prometheus.Collector) error {
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *collectorRegisterer) MustRegister(cs ...prometheus.Collector) {
+	r.collectors = append(r.collectors, cs...)
+}
+
+func (r *collectorRegisterer) Unregister(prometheus.Collector) bool {
+	return false
+}
+
+// SetMetricLabels 设置所有 rpc_server_ 指标的常量标签(例如region、cluster、version),替换之前设置的标签,为空时清除.
+// 服务端指标由进程内的所有服务器共享,因此常量标签是进程级的设置,通常在启动时设置一次;
+// 标签名称必须符合prometheus的规范且不能与指标自身的标签重名,不合法时返回错误并保留之前的标签.
+func SetMetricLabels(tags ...trace.Tag) error {
+	labels := prometheus.Labels(tagValues(tags))
+	m := _serverMetrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for name := range labels {
+		if !validLabelName(name) {
+			return errors.Errorf("指标标签名称[%s]不合法", name)
+		}
+		for _, sm := range m.metrics {
+			for _, l := range sm.labels {
+				if l == name {
+					return errors.Errorf("指标标签名称[%s]与服务端指标的标签重名", name)
+				}
+			}
+		}
+	}
+	m.labels, m.wrapped = labels, m.wrap(labels)
+	return nil
+}
+
+// 引擎创建的服务器的标签,作为服务端指标的常量标签,服务端指标由进程内的所有服务器共享,因此所有服务器的标签必须相同
+var _engineTags = struct {
+	sync.Mutex
+	servers map[*Server]map[string]string
+}{servers: make(map[*Server]map[string]string)}
+
+// applyMetricTags 将引擎创建的服务器的标签设置为服务端指标的常量标签,
+// 与进程内其他服务器的标签不同或者标签名称不合法时返回错误,并保留之前的标签.
+func applyMetricTags(s *Server, tags []trace.Tag) error {
+	labels := tagValues(tags)
+	_engineTags.Lock()
+	defer _engineTags.Unlock()
+	for other, ls := range _engineTags.servers {
+		if other != s && !reflect.DeepEqual(ls, labels) {
+			return errors.Errorf("服务器标签%v与进程内其他服务器的标签%v不同,服务端指标的常量标签必须相同", labels, ls)
+		}
+	}
+	if err := SetMetricLabels(tags...); err != nil {
+		return err
+	}
+	_engineTags.servers[s] = labels
+	return nil
+}
+
+// releaseMetricTags 服务器关闭后不再参与标签的比较,所有服务器都关闭后清除常量标签
+func releaseMetricTags(s *Server) {
+	_engineTags.Lock()
+	defer _engineTags.Unlock()
+	if _, ok := _engineTags.servers[s]; !ok {
+		return
+	}
+	delete(_engineTags.servers, s)
+	if len(_engineTags.servers) == 0 {
+		_ = SetMetricLabels()
+	}
+}
+
+// serverCounterVec 实现 metric.CounterVec 的服务端计数器
+type serverCounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+func newServerCounterVec(cfg *metric.CounterVecOpts) metric.CounterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      cfg.Name,
+		Help:      cfg.Help,
+	}, cfg.Labels)
+	_serverMetrics.register(vec, cfg.Labels)
+	return serverCounterVec{vec: vec}
+}
+
+func (c serverCounterVec) Inc(labels ...string) {
+	c.vec.WithLabelValues(labels...).Inc()
+}
+
+func (c serverCounterVec) Add(v float64, labels ...string) {
+	c.vec.WithLabelValues(labels...).Add(v)
+}
+
+// serverGaugeVec 实现 metric.GaugeVec 的服务端仪表盘
+type serverGaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+func newServerGaugeVec(cfg *metric.GaugeVecOpts) metric.GaugeVec {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      cfg.Name,
+		Help:      cfg.Help,
+	}, cfg.Labels)
+	_serverMetrics.register(vec, cfg.Labels)
+	return serverGaugeVec{vec: vec}
+}
+
+func (g serverGaugeVec) Inc(labels ...string) {
+	g.vec.WithLabelValues(labels...).Inc()
+}
+
+func (g serverGaugeVec) Add(v float64, labels ...string) {
+	g.vec.WithLabelValues(labels...).Add(v)
+}
+
+func (g serverGaugeVec) Set(v float64, labels ...string) {
+	g.vec.WithLabelValues(labels...).Set(v)
+}
+
+// serverHistogramVec 实现 metric.HistogramVec 的服务端直方图
+type serverHistogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+func newServerHistogramVec(cfg *metric.HistogramVecOpts) metric.HistogramVec {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      cfg.Name,
+		Help:      cfg.Help,
+		Buckets:   cfg.Buckets,
+	}, cfg.Labels)
+	_serverMetrics.register(vec, cfg.Labels)
+	return serverHistogramVec{vec: vec}
+}
+
+func (h serverHistogramVec) Observe(v int64, labels ...string) {
+	h.vec.WithLabelValues(labels...).Observe(float64(v))
+}
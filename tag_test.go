@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aluka-7/trace"
+	"github.com/aluka-7/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerTags(t *testing.T) {
+	s1 := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})
+	s2 := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})
+	s1.SetTags(trace.String("region", "sh"), trace.String("app.version", "1.2.0"), trace.Int("shard", 3))
+	s2.SetTags(trace.String("region", "bj"))
+	// 每个服务器的标签互不影响
+	assert.Equal(t, map[string]string{"region": "sh", "app.version": "1.2.0", "shard": "3"}, s1.Metadata())
+	assert.Equal(t, map[string]string{"region": "bj"}, s2.Metadata())
+	s2.SetTags()
+	assert.Equal(t, map[string]string{"region": "sh", "app.version": "1.2.0", "shard": "3"}, s1.Metadata())
+	assert.Empty(t, s2.Metadata())
+}
+
+func TestMetricLabels(t *testing.T) {
+	const method = "/testproto.Greeter/TestMetricLabels"
+	labels := func(name string) map[string]string {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		assert.Nil(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() != name {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				values := make(map[string]string)
+				for _, lp := range m.GetLabel() {
+					values[lp.GetName()] = lp.GetValue()
+				}
+				if values["method"] == method {
+					return values
+				}
+			}
+		}
+		return nil
+	}
+	metricServerCoalescedTotal.Inc(method)
+	metricClientCacheTotal.Inc(method, "hit")
+
+	assert.Nil(t, SetMetricLabels(trace.String("region", "sh"), trace.Int("shard", 3)))
+	defer SetMetricLabels()
+	// 常量标签添加到默认注册表的服务端指标上,已有的计数保留,客户端指标不添加标签
+	metricServerCoalescedTotal.Inc(method)
+	assert.Equal(t, map[string]string{"method": method, "region": "sh", "shard": "3"}, labels("rpc_server_requests_coalesced_total"))
+	assert.Equal(t, 2.0, counterValue("rpc_server_requests_coalesced_total", map[string]string{"method": method}))
+	assert.Equal(t, map[string]string{"method": method, "result": "hit"}, labels("rpc_client_cache_requests_total"))
+
+	// 不合法或者与指标标签重名的名称被拒绝,并保留之前的标签
+	for _, name := range []string{"1region", "__region", "app.version", "", "method"} {
+		assert.NotNil(t, SetMetricLabels(trace.String(name, "x")), name)
+	}
+	assert.Equal(t, map[string]string{"method": method, "region": "sh", "shard": "3"}, labels("rpc_server_requests_coalesced_total"))
+
+	assert.Nil(t, SetMetricLabels())
+	assert.Equal(t, map[string]string{"method": method}, labels("rpc_server_requests_coalesced_total"))
+}
+
+func TestApplyMetricTags(t *testing.T) {
+	const method = "/testproto.Greeter/TestApplyMetricTags"
+	s1 := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})
+	s2 := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})
+	metricServerCoalescedTotal.Inc(method)
+	labels := func() map[string]string {
+		mfs, _ := prometheus.DefaultGatherer.Gather()
+		for _, mf := range mfs {
+			for _, m := range mf.GetMetric() {
+				values := make(map[string]string)
+				for _, lp := range m.GetLabel() {
+					values[lp.GetName()] = lp.GetValue()
+				}
+				if mf.GetName() == "rpc_server_requests_coalesced_total" && values["method"] == method {
+					return values
+				}
+			}
+		}
+		return nil
+	}
+
+	assert.Nil(t, applyMetricTags(s1, []trace.Tag{trace.String("region", "sh")}))
+	assert.Equal(t, map[string]string{"method": method, "region": "sh"}, labels())
+	// 标签与其他服务器不同时返回错误并保留之前的标签
+	assert.NotNil(t, applyMetricTags(s2, []trace.Tag{trace.String("region", "bj")}))
+	assert.Nil(t, applyMetricTags(s2, []trace.Tag{trace.String("region", "sh")}))
+	assert.NotNil(t, applyMetricTags(s1, []trace.Tag{trace.String("region", "bj")}))
+	assert.Equal(t, map[string]string{"method": method, "region": "sh"}, labels())
+
+	// 其他服务器关闭后可以修改标签,所有服务器都关闭后清除标签
+	releaseMetricTags(s2)
+	assert.Nil(t, applyMetricTags(s1, []trace.Tag{trace.String("region", "bj")}))
+	assert.Equal(t, map[string]string{"method": method, "region": "bj"}, labels())
+	releaseMetricTags(s1)
+	assert.Equal(t, map[string]string{"method": method}, labels())
+}
@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/metric"
+	"github.com/aluka-7/utils"
+	"google.golang.org/grpc"
+)
+
+// ThrottleConfig 客户端自适应限流配置,参考Google SRE中的客户端限流算法:
+// 当请求数超过K倍的被接受请求数时,以 max(0, (requests - K*accepts) / (requests + 1)) 的概率在本地直接拒绝请求.
+type ThrottleConfig struct {
+	K      float64        `json:"k"`      // 倍率,越小越激进,默认值为 2
+	Window utils.Duration `json:"window"` // 统计窗口,默认值为 10s
+	Bucket int            `json:"bucket"` // 统计窗口的桶数量,默认值为 40
+}
+
+func (tc *ThrottleConfig) window() (time.Duration, int) {
+	window, bucket := time.Second*10, 40
+	if tc.Window > 0 {
+		window = time.Duration(tc.Window)
+	}
+	if tc.Bucket > 0 {
+		bucket = tc.Bucket
+	}
+	return window, bucket
+}
+
+// throttle 单个目标的请求以及被接受请求的统计
+type throttle struct {
+	mutex    sync.Mutex
+	window   time.Duration
+	bucket   int
+	requests metric.RollingCounter
+	accepts  metric.RollingCounter
+}
+
+// counters 返回统计计数器,统计窗口的配置发生变化时重新统计
+func (t *throttle) counters(conf *ThrottleConfig) (metric.RollingCounter, metric.RollingCounter) {
+	window, bucket := conf.window()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.requests == nil || t.window != window || t.bucket != bucket {
+		opts := metric.RollingCounterOpts{Size: bucket, BucketDuration: window / time.Duration(bucket)}
+		t.window, t.bucket = window, bucket
+		t.requests, t.accepts = metric.NewRollingCounter(opts), metric.NewRollingCounter(opts)
+	}
+	return t.requests, t.accepts
+}
+
+// isOverload 判断服务端是否因为过载拒绝了请求
+func isOverload(err error) bool {
+	switch metacode.Cause(err).Code() {
+	case metacode.ServiceUnavailable.Code(), metacode.LimitExceed.Code(), metacode.Deadline.Code():
+		return true
+	}
+	return false
+}
+
+// throttle 返回自适应限流的客户端拦截器,每个目标独立统计.
+func (c *Client) throttle(target string) grpc.UnaryClientInterceptor {
+	t := new(throttle)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c.mutex.RLock()
+		conf := c.conf.Throttle
+		c.mutex.RUnlock()
+		if conf == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		k := conf.K
+		if k <= 0 {
+			k = 2
+		}
+		requests, accepts := t.counters(conf)
+		ratio := math.Max(0, (requests.Sum()-k*accepts.Sum())/(requests.Sum()+1))
+		metricClientThrottleRatio.Set(ratio, target)
+		requests.Add(1)
+		if ratio > 0 && rand.Float64() < ratio {
+			metricClientThrottleRejectTotal.Inc(target, method)
+			return metacode.ServiceUnavailable
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if !isOverload(err) {
+			accepts.Add(1)
+		}
+		return err
+	}
+}
@@ -0,0 +1,36 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestThrottle(t *testing.T) {
+	c := NewClient(&ClientConfig{Timeout: utils.Duration(time.Second), Throttle: &ThrottleConfig{K: 1.5}})
+	interceptor := c.throttle("127.0.0.1:9000")
+	var invoked int
+	overload := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked++
+		return metacode.ServiceUnavailable
+	}
+	for i := 0; i < 200; i++ {
+		err := interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, nil, nil, overload)
+		assert.True(t, metacode.EqualError(metacode.ServiceUnavailable, err))
+	}
+	// 服务端持续过载时大部分请求在本地被拒绝
+	assert.True(t, invoked < 100, "invoked: %d", invoked)
+
+	// 关闭限流后所有请求都会发送到服务端
+	_ = c.SetConfig(&ClientConfig{Timeout: utils.Duration(time.Second)})
+	invoked = 0
+	for i := 0; i < 10; i++ {
+		_ = interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, nil, nil, overload)
+	}
+	assert.Equal(t, 10, invoked)
+}
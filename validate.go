@@ -0,0 +1,215 @@
+package grpc
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aluka-7/metacode"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTrans "github.com/go-playground/validator/v10/translations/en"
+	zhTrans "github.com/go-playground/validator/v10/translations/zh"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// defaultLocale 默认的校验错误提示语言
+const defaultLocale = "zh"
+
+// streamValidateReason 流消息校验失败时ErrorInfo中的原因
+const streamValidateReason = "STREAM_MESSAGE_INVALID"
+
+// acceptLanguage 客户端通过该元数据指定期望的校验错误提示语言,例如:en-US,zh;q=0.9
+const acceptLanguage = "accept-language"
+
+var (
+	validate = validator.New()
+	uni      = ut.New(zh.New(), zh.New(), en.New())
+)
+
+func init() {
+	// 使用json标签作为字段名称,便于客户端直接定位请求中的字段
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	if trans, ok := uni.GetTranslator("zh"); ok {
+		if err := zhTrans.RegisterDefaultTranslations(validate, trans); err != nil {
+			panic(err)
+		}
+	}
+	if trans, ok := uni.GetTranslator("en"); ok {
+		if err := enTrans.RegisterDefaultTranslations(validate, trans); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// 验证返回一个服务器拦截器,以验证每个RPC调用的传入请求.
+func (s *Server) validate() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, args *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if err = validate.Struct(req); err != nil {
+			s.mutex.RLock()
+			conf := s.conf
+			s.mutex.RUnlock()
+			err = validateError(err, translator(ctx, conf.Locale))
+			return
+		}
+		resp, err = handler(ctx, req)
+		return
+	}
+}
+
+// streamValidate 返回一个流服务器拦截器,对客户端流以及双向流中接收到的每条消息使用与一元请求相同的规则进行校验,
+// 校验失败时RecvMsg返回metacode.ValidateErr对应的gRPC状态,错误详情中的ErrorInfo携带出错消息的序号(从0开始).
+// 处理程序的结果原样返回,不会修改其状态码.
+func (s *Server) streamValidate() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, args *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !args.IsClientStream {
+			return handler(srv, ss)
+		}
+		return handler(srv, &validateStream{ServerStream: ss, server: s, method: args.FullMethod})
+	}
+}
+
+// validateStream 校验每条接收到的消息
+type validateStream struct {
+	grpc.ServerStream
+	server *Server
+	method string
+	index  int // 下一条消息的序号
+}
+
+func (vs *validateStream) RecvMsg(m interface{}) error {
+	if err := vs.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	index := vs.index
+	vs.index++
+	// 未注册服务的处理程序接收的是序列化后的数据,不需要校验
+	if _, ok := m.(proto.Message); !ok {
+		return nil
+	}
+	if err := validate.Struct(m); err != nil {
+		vs.server.mutex.RLock()
+		conf := vs.server.conf
+		vs.server.mutex.RUnlock()
+		return FromError(validateBuilder(err, translator(vs.Context(), conf.Locale)).
+			WithErrorInfo(streamValidateReason, vs.method, map[string]string{"index": strconv.Itoa(index)}).Err()).Err()
+	}
+	return nil
+}
+
+// translator 按照客户端的accept-language元数据以及服务器配置的语言查找翻译器
+func translator(ctx context.Context, locale string) ut.Translator {
+	var locales []string
+	if gmd, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range gmd.Get(acceptLanguage) {
+			for _, lang := range strings.Split(v, ",") {
+				lang = strings.TrimSpace(strings.SplitN(lang, ";", 2)[0])
+				if lang == "" {
+					continue
+				}
+				lang = strings.Replace(lang, "-", "_", -1)
+				locales = append(locales, lang, strings.SplitN(lang, "_", 2)[0])
+			}
+		}
+	}
+	if locale == "" {
+		locale = defaultLocale
+	}
+	locales = append(locales, locale)
+	trans, _ := uni.FindTranslator(locales...)
+	return trans
+}
+
+// validateError 将校验错误转换为携带逐字段详情(errdetails.BadRequest)的metacode.ValidateErr
+func validateError(err error, trans ut.Translator) error {
+	return validateBuilder(err, trans).Err()
+}
+
+// validateBuilder 使用校验错误构建metacode.ValidateErr
+func validateBuilder(err error, trans ut.Translator) *ErrorBuilder {
+	ves, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return NewErrorBuilder(metacode.ValidateErr, err.Error())
+	}
+	msgs := make([]string, 0, len(ves))
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(ves))
+	for _, fe := range ves {
+		msg := fe.Translate(trans)
+		msgs = append(msgs, msg)
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       fieldPath(fe.Namespace()),
+			Description: msg,
+		})
+	}
+	return NewErrorBuilder(metacode.ValidateErr, strings.Join(msgs, "; ")).WithBadRequest(violations...)
+}
+
+// StreamMessageIndex 返回流消息校验失败时出错消息的序号.
+func StreamMessageIndex(err error) (int, bool) {
+	ei, ok := ErrorInfo(err)
+	if !ok || ei.GetReason() != streamValidateReason {
+		return 0, false
+	}
+	index, e := strconv.Atoi(ei.GetMetadata()["index"])
+	return index, e == nil
+}
+
+// fieldPath 去掉命名空间中最外层的结构体名称,例如:HelloRequest.name -> name
+func fieldPath(ns string) string {
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// FieldViolations 从校验错误中提取逐字段的错误信息,键为请求字段路径,值为本地化后的提示信息.
+func FieldViolations(err error) map[string]string {
+	violations := make(map[string]string)
+	if br, ok := BadRequest(err); ok {
+		for _, fv := range br.GetFieldViolations() {
+			violations[fv.GetField()] = fv.GetDescription()
+		}
+	}
+	return violations
+}
+
+// RegisterValidation 将验证功能添加到由键表示的验证者的验证者映射中
+// 注意:如果密钥已经存在,则先前的验证功能将被替换。
+// 注意:此方法不是线程安全的,因此应在进行任何验证之前先将它们全部注册
+func (s *Server) RegisterValidation(key string, fn validator.Func) error {
+	return validate.RegisterValidation(key, fn)
+}
+
+// RegisterTranslation 为自定义的验证标签注册指定语言的提示信息,text中的{0}将被替换为字段名称.
+// 注意:此方法不是线程安全的,因此应在进行任何验证之前先将它们全部注册
+func (s *Server) RegisterTranslation(tag, locale, text string) error {
+	trans, ok := uni.GetTranslator(locale)
+	if !ok {
+		return errors.Errorf("rpc: unsupported locale %s", locale)
+	}
+	return validate.RegisterTranslation(tag, trans, func(ut ut.Translator) error {
+		return ut.Add(tag, text, true)
+	}, func(ut ut.Translator, fe validator.FieldError) string {
+		msg, err := ut.T(fe.Tag(), fe.Field())
+		if err != nil {
+			return fe.Error()
+		}
+		return msg
+	})
+}
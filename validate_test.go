@@ -0,0 +1,138 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/aluka-7/grpc/testproto"
+	"github.com/aluka-7/metacode"
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateTranslation(t *testing.T) {
+	s := &Server{conf: &ServerConfig{Timeout: utils.Duration(time.Second)}}
+	interceptor := s.validate()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &pb.HelloReply{Success: true}, nil
+	}
+	args := &grpc.UnaryServerInfo{FullMethod: "/testproto.Greeter/SayHello"}
+
+	t.Run("default locale", func(t *testing.T) {
+		_, err := interceptor(context.Background(), &pb.HelloRequest{}, args, handler)
+		assert.True(t, metacode.EqualError(metacode.ValidateErr, err))
+		assert.Equal(t, "name为必填字段", metacode.Cause(err).Message())
+		assert.Equal(t, map[string]string{"name": "name为必填字段"}, FieldViolations(err))
+	})
+	t.Run("accept-language", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(acceptLanguage, "en-US,zh;q=0.9"))
+		_, err := interceptor(ctx, &pb.HelloRequest{}, args, handler)
+		assert.True(t, metacode.EqualError(metacode.ValidateErr, err))
+		assert.Equal(t, map[string]string{"name": "name is a required field"}, FieldViolations(err))
+	})
+	t.Run("valid request", func(t *testing.T) {
+		resp, err := interceptor(context.Background(), &pb.HelloRequest{Name: "aluka"}, args, handler)
+		assert.Nil(t, err)
+		assert.True(t, resp.(*pb.HelloReply).Success)
+	})
+}
+
+type streamServer struct {
+	testServer
+}
+
+func (s *streamServer) StreamHello(ss pb.Greeter_StreamHelloServer) error {
+	for {
+		req, err := ss.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = ss.Send(&pb.HelloReply{Message: req.Name, Success: true}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestStreamValidate(t *testing.T) {
+	srv := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})
+	pb.RegisterGreeterServer(srv.Server(), &streamServer{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go srv.Serve(lis)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := NewConn(lis.Addr().String(), &ClientConfig{Dial: utils.Duration(time.Second * 10), Timeout: utils.Duration(time.Second)}, []string{"10000"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	stream, err := pb.NewGreeterClient(conn).StreamHello(context.Background())
+	assert.Nil(t, err)
+	for _, name := range []string{"a", "b", ""} {
+		assert.Nil(t, stream.Send(&pb.HelloRequest{Name: name}))
+	}
+	_ = stream.CloseSend()
+	var replies []string
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			assert.Equal(t, metacode.ValidateErr.Code(), ToMetaCode(status.Convert(err)).Code())
+			assert.Equal(t, map[string]string{"name": "name为必填字段"}, FieldViolations(err))
+			index, ok := StreamMessageIndex(err)
+			assert.True(t, ok)
+			assert.Equal(t, 2, index)
+			break
+		}
+		replies = append(replies, reply.Message)
+	}
+	assert.Equal(t, []string{"a", "b"}, replies)
+}
+
+// recvStream 接收消息时不做任何处理的服务端流
+type recvStream struct {
+	grpc.ServerStream
+}
+
+func (recvStream) Context() context.Context { return context.Background() }
+
+func (recvStream) RecvMsg(m interface{}) error { return nil }
+
+func TestStreamValidateRawMessage(t *testing.T) {
+	vs := &validateStream{ServerStream: recvStream{}, server: NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)})}
+	// 非proto消息(例如未注册服务接收的原始数据)不校验
+	var raw []byte
+	assert.Nil(t, vs.RecvMsg(&raw))
+	// 校验失败时返回最终的gRPC状态
+	st, ok := status.FromError(vs.RecvMsg(&pb.HelloRequest{}))
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, metacode.ValidateErr.Code(), ToMetaCode(st).Code())
+	assert.Equal(t, 2, vs.index)
+}
+
+func TestStreamValidateHandlerError(t *testing.T) {
+	interceptor := NewServer(&ServerConfig{Timeout: utils.Duration(time.Second)}).streamValidate()
+	want := errors.New("server stream failed")
+	// 服务端流的处理程序的结果原样返回
+	err := interceptor(nil, recvStream{}, &grpc.StreamServerInfo{IsServerStream: true}, func(srv interface{}, ss grpc.ServerStream) error {
+		_, ok := ss.(*validateStream)
+		assert.False(t, ok)
+		return want
+	})
+	assert.Same(t, want, err)
+
+	// 客户端流以及双向流的处理程序的结果同样原样返回
+	err = interceptor(nil, recvStream{}, &grpc.StreamServerInfo{IsClientStream: true, IsServerStream: true}, func(srv interface{}, ss grpc.ServerStream) error {
+		return context.Canceled
+	})
+	assert.Equal(t, context.Canceled, err)
+}